@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// The labels set by the Kubernetes controllers, which are accepted by the
+// allowlist unless `ignored_labels` is set
+var defaultIgnoredLabels = []string{
+	"apps.kubernetes.io/pod-index",
+	"batch.kubernetes.io/controller-uid",
+	"batch.kubernetes.io/job-name",
+	"controller-revision-hash",
+	"controller-uid",
+	"job-name",
+	"pod-template-hash",
+	"statefulset.kubernetes.io/pod-name",
+}
+
+// AllowedLabels rejects the labels that are not explicitly allowed:
+//
+//	{
+//	  "keys": ["app", "example.com/*", "*-id"],
+//	  "exclusive": true,
+//	  "ignored_labels": ["pod-template-hash"]
+//	}
+//
+// The keys of the mandatory and constrained rules are allowed too, together
+// with the ones required by the conditions, unless the allowlist is
+// exclusive
+type AllowedLabels struct {
+	// Label keys, prefixes or key patterns, like `example.com/*`
+	Keys []string `json:"keys"`
+	// Allow only the keys listed by the allowlist, ignoring the rules
+	Exclusive bool `json:"exclusive,omitempty"`
+	// The labels managed by the system, always allowed. Defaults to
+	// defaultIgnoredLabels
+	IgnoredLabels []string `json:"ignored_labels"`
+}
+
+// Builds the structure matching the allowed label keys
+func (s *Settings) compileAllowedKeys() {
+	s.allowedKeys = nil
+	if s.AllowedLabels == nil {
+		return
+	}
+	keys := slices.Concat(s.AllowedLabels.Keys, s.AllowedLabels.IgnoredLabels)
+	if !s.AllowedLabels.Exclusive {
+		for _, rule := range s.Rules() {
+			if rule.Type != DeniedRule {
+				keys = append(keys, rule.Key)
+			}
+		}
+		for _, condition := range s.Conditions {
+			keys = append(keys, condition.Then.keys()...)
+		}
+	}
+	s.allowedKeys = newKeyMatcher(keys)
+}
+
+// Returns true when the label is not allowed by the allowlist
+func (s *Settings) notAllowed(label string) bool {
+	if s.allowedKeys == nil {
+		return false
+	}
+	_, allowed := s.allowedKeys.match(label)
+	return !allowed
+}
+
+// Checks that the mandatory labels are allowed, otherwise all the objects
+// would be rejected
+func (s *Settings) validateAllowedLabels() []string {
+	errors := []string{}
+	if s.allowedKeys == nil {
+		return errors
+	}
+
+	if s.allowedKeys.programSize > s.Limits.maxKeyPatternsProgramSize() {
+		errors = append(errors, fmt.Sprintf(
+			"the allowed key patterns are too complex: they compile to %d instructions, the limit is %d",
+			s.allowedKeys.programSize, s.Limits.maxKeyPatternsProgramSize()))
+	}
+
+	// denied labels are always rejected, allowing them is a mistake
+	for _, key := range s.AllowedLabels.Keys {
+		if isKeyPattern(key) {
+			continue
+		}
+		if _, denied := s.deniedRule(key); denied {
+			errors = append(errors, fmt.Sprintf("label %s cannot be denied and allowed at the same time", key))
+		}
+	}
+
+	missing := []string{}
+	for label := range s.MandatoryLabels.Iter() {
+		if s.notAllowed(label) {
+			missing = append(missing, label)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		errors = append(errors, fmt.Sprintf(
+			"mandatory labels %s are not allowed by allowed_labels", strings.Join(missing, ",")))
+	}
+	return errors
+}
+
+// Decodes the allowlist
+func (d *settingsDecoder) allowedLabels(path string, raw json.RawMessage) (*AllowedLabels, bool) {
+	allowed := &AllowedLabels{Keys: []string{}}
+	ignoredSet := false
+	errsBefore := len(d.errs)
+
+	keys := func(keys *[]string) fieldDecoder {
+		return func(path string, raw json.RawMessage) {
+			d.array(path, raw, func(path string, raw json.RawMessage) {
+				// keys can be patterns, like the ones of the denied rules
+				if key, ok := d.string(path, raw); ok && d.ruleKey(path, key, DeniedRule) {
+					*keys = append(*keys, key)
+				}
+			})
+		}
+	}
+
+	d.object(path, raw, fieldDecoders{
+		"keys": keys(&allowed.Keys),
+		"exclusive": func(path string, raw json.RawMessage) {
+			allowed.Exclusive, _ = d.bool(path, raw)
+		},
+		"ignored_labels": func(path string, raw json.RawMessage) {
+			ignoredSet = true
+			allowed.IgnoredLabels = []string{}
+			keys(&allowed.IgnoredLabels)(path, raw)
+		},
+	})
+	if !ignoredSet {
+		allowed.IgnoredLabels = slices.Clone(defaultIgnoredLabels)
+	}
+	return allowed, len(d.errs) == errsBefore
+}
+
+// Returns the allowlist of a profile: its own one, or the one of the last
+// profile it extends that has one. Nil when the allowlist of the settings
+// applies
+func (s *Settings) profileAllowedLabels(name string, visited map[string]bool) *AllowedLabels {
+	if visited[name] {
+		return nil
+	}
+	visited[name] = true
+
+	profile := s.Profiles[name]
+	if profile.AllowedLabels != nil {
+		return profile.AllowedLabels
+	}
+	for i := len(profile.Extends) - 1; i >= 0; i-- {
+		if allowed := s.profileAllowedLabels(profile.Extends[i], visited); allowed != nil {
+			return allowed
+		}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+	kubewarden_testing "github.com/kubewarden/policy-sdk-go/testing"
+)
+
+func TestAllowedLabels(t *testing.T) {
+	cases := []struct {
+		name             string
+		fixture          string
+		settings         string
+		expectedAccepted bool
+		expectedMessage  string
+	}{
+		{
+			name:            "label not allowed",
+			fixture:         "test_data/pod.json",
+			settings:        `"allowed_labels": { "keys": [ "app", "environment" ] }`,
+			expectedMessage: "The following labels are not allowed: ownr",
+		},
+		{
+			name:             "labels allowed by prefixes and patterns",
+			fixture:          "test_data/pod.json",
+			settings:         `"allowed_labels": { "keys": [ "app", "env*", "*r" ] }`,
+			expectedAccepted: true,
+		},
+		{
+			name:    "labels allowed by the rules",
+			fixture: "test_data/pod.json",
+			settings: `"allowed_labels": { "keys": [ "app", "environment" ] },
+				"rules": [ { "type": "constrained", "key": "ownr", "pattern": "^team-" } ]`,
+			expectedAccepted: true,
+		},
+		{
+			name:    "exclusive allowlist ignoring the rules",
+			fixture: "test_data/pod.json",
+			settings: `"allowed_labels": { "keys": [ "app", "environment" ], "exclusive": true },
+				"rules": [ { "type": "constrained", "key": "ownr", "pattern": "^team-" } ]`,
+			expectedMessage: "The following labels are not allowed: ownr",
+		},
+		{
+			name:             "labels managed by the system",
+			fixture:          "test_data/owned_pod.json",
+			settings:         `"allowed_labels": { "keys": [ "app", "team" ], "exclusive": true }`,
+			expectedAccepted: true,
+		},
+		{
+			name:            "labels managed by the system, overriding the ignored labels",
+			fixture:         "test_data/owned_pod.json",
+			settings:        `"allowed_labels": { "keys": [ "app", "team" ], "ignored_labels": [] }`,
+			expectedMessage: "The following labels are not allowed: pod-template-hash",
+		},
+		{
+			name:    "allowlist of the profile selected for the namespace",
+			fixture: "test_data/pod.json",
+			settings: `"profiles": { "regulated": { "allowed_labels": { "keys": [ "app" ] } } },
+				"profile_selectors": [ { "profile": "regulated", "namespaces": [ "payments" ] } ]`,
+			expectedMessage: "The following labels are not allowed: ownr,environment",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			settings, err := NewSettingsFromValidateSettingsPayload([]byte(`
+			{
+				"version": 2,
+				` + tc.settings + `
+			}`))
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+			if valid, err := settings.Valid(); !valid {
+				t.Fatalf("Expected settings to be valid: %v", err)
+			}
+
+			payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+				tc.fixture,
+				&settings)
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			responsePayload, err := validate(payload)
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			var response kubewarden_protocol.ValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			if response.Accepted != tc.expectedAccepted {
+				t.Fatalf("Unexpected response: %+v", response)
+			}
+			if tc.expectedAccepted {
+				return
+			}
+			if *response.Message != tc.expectedMessage {
+				t.Errorf("Unexpected rejection message: %s", *response.Message)
+			}
+		})
+	}
+}
+
+func TestDetectNotValidAllowedLabels(t *testing.T) {
+	cases := []struct {
+		name            string
+		settings        string
+		expectedMessage string
+	}{
+		{
+			name:            "not valid key pattern",
+			settings:        `"allowed_labels": { "keys": [ "-app*" ] }`,
+			expectedMessage: `/allowed_labels/keys/0: "-app*" is not a valid label key pattern`,
+		},
+		{
+			name: "mandatory label not allowed",
+			settings: `"allowed_labels": { "keys": [ "app" ], "exclusive": true },
+				"rules": [ { "type": "mandatory", "key": "owner" } ]`,
+			expectedMessage: "mandatory labels owner are not allowed by allowed_labels",
+		},
+		{
+			name: "denied label allowed",
+			settings: `"allowed_labels": { "keys": [ "app", "debug" ] },
+				"rules": [ { "type": "denied", "key": "debug" } ]`,
+			expectedMessage: "label debug cannot be denied and allowed at the same time",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			responsePayload, err := validateSettings([]byte(`
+			{
+				"version": 2,
+				` + tc.settings + `
+			}`))
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			var response kubewarden_protocol.SettingsValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			if response.Valid {
+				t.Fatal("Expected settings to not be valid")
+			}
+			if !strings.Contains(*response.Message, tc.expectedMessage) {
+				t.Errorf("Unexpected message: %s", *response.Message)
+			}
+		})
+	}
+}
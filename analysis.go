@@ -0,0 +1,307 @@
+package main
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"sort"
+	"strings"
+)
+
+// The strictness levels of the settings analysis
+const (
+	// Skip the analysis
+	AnalysisOff = "off"
+	// Contradictions are errors, suspicious rules are warnings
+	AnalysisDefault = "default"
+	// All the findings are errors
+	AnalysisStrict = "strict"
+)
+
+var analysisLevels = []string{AnalysisOff, AnalysisDefault, AnalysisStrict}
+
+// A problem found by the settings analysis
+type finding struct {
+	// Contradictions are always reported, unless the analysis is off
+	contradiction bool
+	message       string
+}
+
+// Looks for rules that contradict each other or that are not going to
+// behave as the user expects. This goes beyond the checks done by `Valid`,
+// which are about conflicting rule categories
+func (s *Settings) analyze() []finding {
+	findings := []finding{}
+
+	labels := make([]string, 0, len(s.ConstrainedLabels))
+	for label := range s.ConstrainedLabels {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	for _, label := range labels {
+		re := s.ConstrainedLabels[label]
+		if re == nil || re.Regexp == nil {
+			continue
+		}
+		mandatory := s.MandatoryLabels.Contains(label)
+
+		// The allowed values are analyzed one by one, the regular
+		// expression generated from them doesn't add anything
+		if allowedValues, found := s.AllowedValues[label]; found {
+			for _, value := range allowedValues {
+				if problems := labelValueErrors(value); len(problems) > 0 {
+					findings = append(findings, finding{
+						message: fmt.Sprintf(
+							"allowed value %q of constrained label %s can never be used: %s",
+							value, label, strings.Join(problems, ", ")),
+					})
+				}
+			}
+			continue
+		}
+
+		parsed, err := syntax.Parse(re.String(), syntax.Perl)
+		if err != nil {
+			continue
+		}
+		parsed = parsed.Simplify()
+
+		if reason := neverMatchesLabelValue(parsed); reason != "" {
+			if mandatory {
+				findings = append(findings, finding{
+					contradiction: true,
+					message: fmt.Sprintf(
+						"mandatory label %s can never be satisfied: its constraint %s %s",
+						label, re.String(), reason),
+				})
+			} else {
+				findings = append(findings, finding{
+					message: fmt.Sprintf(
+						"constrained label %s is effectively denied: its constraint %s %s",
+						label, re.String(), reason),
+				})
+			}
+			continue
+		}
+
+		if acceptsAnyValue(parsed, re) {
+			findings = append(findings, finding{
+				message: fmt.Sprintf(
+					"constraint %s of label %s accepts any value, it has no effect",
+					re.String(), label),
+			})
+			continue
+		}
+
+		if mandatory && re.MatchString("") {
+			findings = append(findings, finding{
+				message: fmt.Sprintf(
+					"mandatory label %s accepts an empty value: its constraint %s matches the empty string",
+					label, re.String()),
+			})
+		}
+	}
+
+	return append(findings, s.shadowedDeniedRules()...)
+}
+
+// Looks for denied rules that are already covered by a denied pattern
+func (s *Settings) shadowedDeniedRules() []finding {
+	findings := []finding{}
+	if s.deniedKeys == nil {
+		return findings
+	}
+
+	keys := s.DeniedLabels.ToSlice()
+	sort.Strings(keys)
+	for _, key := range keys {
+		if pattern, found := s.deniedKeys.shadowingPattern(key); found {
+			findings = append(findings, finding{
+				message: fmt.Sprintf(
+					"denied rule %s is shadowed by the denied pattern %s, it has no effect",
+					key, pattern),
+			})
+		}
+	}
+
+	return findings
+}
+
+// Returns the findings to be treated as errors, according to the
+// strictness of the analysis
+func (s *Settings) analysisErrors() []string {
+	errors := []string{}
+	if s.Analysis == AnalysisOff {
+		return errors
+	}
+	for _, f := range s.analyze() {
+		if f.contradiction || s.Analysis == AnalysisStrict {
+			errors = append(errors, f.message)
+		}
+	}
+	return errors
+}
+
+// Returns the deprecated fields used by the settings, plus the findings
+// to be treated as warnings according to the strictness of the analysis
+func (s *Settings) Warnings() []string {
+	warnings := append([]string{}, s.Deprecations...)
+	if s.Analysis == AnalysisOff || s.Analysis == AnalysisStrict {
+		return warnings
+	}
+	for _, f := range s.analyze() {
+		if !f.contradiction {
+			warnings = append(warnings, f.message)
+		}
+	}
+	return warnings
+}
+
+// Returns why the given regular expression can never match a valid
+// label value, or an empty string when it can
+func neverMatchesLabelValue(re *syntax.Regexp) string {
+	if requiresInvalidCharacter(re) {
+		return "requires characters that are not allowed inside of label values"
+	}
+	if length := minMatchLength(re); length > labelNameMaxLength {
+		return fmt.Sprintf(
+			"requires at least %d characters, label values cannot be longer than %d",
+			length, labelNameMaxLength)
+	}
+	return ""
+}
+
+// Returns the minimum length of the strings matched by the regular
+// expression. Regular expressions that cannot match anything have an
+// infinite length
+func minMatchLength(re *syntax.Regexp) int {
+	const infinite = int(^uint(0) >> 2)
+
+	switch re.Op {
+	case syntax.OpNoMatch:
+		return infinite
+	case syntax.OpLiteral:
+		return len(re.Rune)
+	case syntax.OpCharClass:
+		if len(re.Rune) == 0 {
+			return infinite
+		}
+		return 1
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return 1
+	case syntax.OpCapture, syntax.OpPlus:
+		return minMatchLength(re.Sub[0])
+	case syntax.OpRepeat:
+		return min(infinite, re.Min*minMatchLength(re.Sub[0]))
+	case syntax.OpConcat:
+		total := 0
+		for _, sub := range re.Sub {
+			total = min(infinite, total+minMatchLength(sub))
+		}
+		return total
+	case syntax.OpAlternate:
+		shortest := infinite
+		for _, sub := range re.Sub {
+			shortest = min(shortest, minMatchLength(sub))
+		}
+		return shortest
+	default:
+		// empty matches, assertions, star and quest
+		return 0
+	}
+}
+
+// Returns true when all the strings matched by the regular expression
+// contain at least one character that is not allowed inside of label
+// values. The regular expression doesn't need to be anchored: the label
+// value would contain the matched string anyway
+func requiresInvalidCharacter(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpNoMatch:
+		return true
+	case syntax.OpLiteral:
+		for _, r := range re.Rune {
+			if !isLabelValueCharacter(r) {
+				return true
+			}
+		}
+		return false
+	case syntax.OpCharClass:
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			if rangeHasLabelValueCharacter(re.Rune[i], re.Rune[i+1]) {
+				return false
+			}
+		}
+		return true
+	case syntax.OpCapture, syntax.OpPlus:
+		return requiresInvalidCharacter(re.Sub[0])
+	case syntax.OpRepeat:
+		return re.Min > 0 && requiresInvalidCharacter(re.Sub[0])
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if requiresInvalidCharacter(sub) {
+				return true
+			}
+		}
+		return false
+	case syntax.OpAlternate:
+		for _, sub := range re.Sub {
+			if !requiresInvalidCharacter(sub) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// The ranges of characters allowed inside of label values
+var labelValueCharacterRanges = [][2]rune{
+	{'-', '.'},
+	{'0', '9'},
+	{'A', 'Z'},
+	{'_', '_'},
+	{'a', 'z'},
+}
+
+func isLabelValueCharacter(r rune) bool {
+	return rangeHasLabelValueCharacter(r, r)
+}
+
+func rangeHasLabelValueCharacter(lo, hi rune) bool {
+	for _, valid := range labelValueCharacterRanges {
+		if lo <= valid[1] && hi >= valid[0] {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true when the regular expression matches any string. That
+// happens when it matches the empty string and it is not anchored on both
+// sides: the empty match can then be found inside of any string
+func acceptsAnyValue(parsed *syntax.Regexp, re *RegularExpression) bool {
+	if !re.MatchString("") {
+		return false
+	}
+
+	beginAnchor, endAnchor, wordBoundary := false, false, false
+	var walk func(*syntax.Regexp)
+	walk = func(node *syntax.Regexp) {
+		switch node.Op {
+		case syntax.OpBeginLine, syntax.OpBeginText:
+			beginAnchor = true
+		case syntax.OpEndLine, syntax.OpEndText:
+			endAnchor = true
+		case syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+			wordBoundary = true
+		}
+		for _, sub := range node.Sub {
+			walk(sub)
+		}
+	}
+	walk(parsed)
+
+	return !wordBoundary && !(beginAnchor && endAnchor)
+}
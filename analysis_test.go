@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp/syntax"
+	"testing"
+
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+)
+
+func TestNeverMatchesLabelValue(t *testing.T) {
+	cases := []struct {
+		expr  string
+		never bool
+	}{
+		{`^team-[a-z]+$`, false},
+		{`.*`, false},
+		{`^[a-z]{64,}$`, true},
+		{`^(a{32}){2}$`, true},
+		{`^team [a-z]+$`, true},
+		{`^team(-| )[a-z]+$`, false},
+		{`^[ /:]+$`, true},
+		{`^[a-z]*( )?$`, false},
+		{`[^\x00-\x{10FFFF}]`, true},
+	}
+
+	for _, tc := range cases {
+		re, err := syntax.Parse(tc.expr, syntax.Perl)
+		if err != nil {
+			t.Fatalf("Unexpected error: %+v", err)
+		}
+
+		reason := neverMatchesLabelValue(re.Simplify())
+		if tc.never && reason == "" {
+			t.Errorf("Expected %s to never match a label value", tc.expr)
+		}
+		if !tc.never && reason != "" {
+			t.Errorf("Expected %s to match some label values, got: %s", tc.expr, reason)
+		}
+	}
+}
+
+func TestSettingsAnalysis(t *testing.T) {
+	cases := []struct {
+		name            string
+		settings        string
+		expectedValid   bool
+		expectedMessage string
+	}{
+		{
+			name: "mandatory label that can never be satisfied",
+			settings: `{
+				"mandatory_labels": ["owner"],
+				"constrained_labels": {"owner": "^team [a-z]+$"}
+			}`,
+			expectedValid: false,
+			expectedMessage: "Provided settings are not valid: mandatory label owner can never be satisfied: " +
+				"its constraint ^team [a-z]+$ requires characters that are not allowed inside of label values",
+		},
+		{
+			name: "contradictions are ignored when the analysis is off",
+			settings: `{
+				"version": 2,
+				"rules": [
+					{"type": "mandatory", "key": "owner"},
+					{"type": "constrained", "key": "owner", "pattern": "^[a-z]{64,}$"}
+				],
+				"analysis": "off"
+			}`,
+			expectedValid: true,
+		},
+		{
+			name: "suspicious rules are reported as warnings",
+			settings: `{
+				"version": 2,
+				"rules": [
+					{"type": "mandatory", "key": "owner"},
+					{"type": "constrained", "key": "owner", "pattern": "^(team-[a-z]+)?$"},
+					{"type": "constrained", "key": "tier", "pattern": ".*"},
+					{"type": "constrained", "key": "env", "allowed_values": ["prod", "pre prod"]}
+				]
+			}`,
+			expectedValid: true,
+			expectedMessage: "Provided settings are valid, but: " +
+				"allowed value \"pre prod\" of constrained label env can never be used: " +
+				"must be empty or consist of alphanumeric characters, '-', '_' or '.', " +
+				"and must start and end with an alphanumeric character; " +
+				"mandatory label owner accepts an empty value: its constraint ^(team-[a-z]+)?$ matches the empty string; " +
+				"constraint .* of label tier accepts any value, it has no effect",
+		},
+		{
+			name: "suspicious rules are errors in strict mode",
+			settings: `{
+				"constrained_labels": {"tier": "^[a-z]{100}$"},
+				"analysis": "strict"
+			}`,
+			expectedValid: false,
+			expectedMessage: "Provided settings are not valid: constrained label tier is effectively denied: " +
+				"its constraint ^[a-z]{100}$ requires at least 100 characters, label values cannot be longer than 63",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			responsePayload, err := validateSettings([]byte(tc.settings))
+			if err != nil {
+				t.Errorf("Unexpected error %+v", err)
+			}
+
+			var response kubewarden_protocol.SettingsValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Errorf("Unexpected error: %+v", err)
+			}
+
+			if response.Valid != tc.expectedValid {
+				t.Errorf("Expected valid to be %v", tc.expectedValid)
+			}
+
+			message := ""
+			if response.Message != nil {
+				message = *response.Message
+			}
+			if message != tc.expectedMessage {
+				t.Errorf("Unexpected validation message: %s", message)
+			}
+		})
+	}
+}
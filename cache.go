@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/sha256"
+	"sync"
+)
+
+// The maximum number of settings documents kept by settingsCache. A policy
+// instance usually sees a single document, the limit protects the memory
+// of the policy when the settings change often
+const settingsCacheSize = 8
+
+// The maximum number of tenant policies kept by their cache, each
+// namespace can have its own ones
+const tenantPoliciesCacheSize = 512
+
+// settingsCache keeps the settings already decoded, indexed by the hash
+// of their raw JSON document. Decoding the settings means compiling all
+// the regular expressions and building the label sets, which is a waste
+// of time when done for every admission request.
+//
+// The cached settings are shared between requests: they must not be
+// modified
+type settingsCache struct {
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]Settings
+	// The maximum number of entries, settingsCacheSize when not set
+	capacity int
+}
+
+var parsedSettings = settingsCache{}
+
+// Returns the settings decoded from the given raw document, decoding
+// them only when they are not already cached. Documents that cannot be
+// decoded are not cached
+func (c *settingsCache) get(raw []byte) (Settings, error) {
+	key := sha256.Sum256(raw)
+
+	c.mu.Lock()
+	settings, found := c.entries[key]
+	c.mu.Unlock()
+	if found {
+		return settings, nil
+	}
+
+	settings, err := NewSettingsFromValidateSettingsPayload(raw)
+	if err != nil {
+		return Settings{}, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil || len(c.entries) >= limitOrDefault(c.capacity, settingsCacheSize) {
+		c.entries = make(map[[sha256.Size]byte]Settings)
+	}
+	c.entries[key] = settings
+
+	return settings, nil
+}
+
+func (c *settingsCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = nil
+}
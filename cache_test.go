@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	kubewarden_testing "github.com/kubewarden/policy-sdk-go/testing"
+)
+
+func TestSettingsCacheDecodesEachDocumentOnce(t *testing.T) {
+	cache := settingsCache{}
+
+	first, err := cache.get([]byte(`{"constrained_labels": {"owner": "^team-"}}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	second, err := cache.get([]byte(`{"constrained_labels": {"owner": "^team-"}}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if first.ConstrainedLabels["owner"] != second.ConstrainedLabels["owner"] {
+		t.Error("Expected the cached regular expression to be reused")
+	}
+
+	other, err := cache.get([]byte(`{"constrained_labels": {"owner": "^squad-"}}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if other.ConstrainedLabels["owner"].String() != "^squad-" {
+		t.Errorf("Unexpected settings: %v", other.ConstrainedLabels)
+	}
+
+	if _, err := cache.get([]byte(`{"constrained_labels": {"owner": "("}}`)); err == nil {
+		t.Error("Expected an error")
+	}
+	if len(cache.entries) != 2 {
+		t.Errorf("Unexpected number of cached documents: %d", len(cache.entries))
+	}
+}
+
+func TestSettingsCacheIsBounded(t *testing.T) {
+	cache := settingsCache{}
+
+	for i := 0; i < settingsCacheSize*2; i++ {
+		if _, err := cache.get([]byte(fmt.Sprintf(`{"denied_labels": ["label-%d"]}`, i))); err != nil {
+			t.Fatalf("Unexpected error: %+v", err)
+		}
+		if len(cache.entries) > settingsCacheSize {
+			t.Fatalf("Too many cached documents: %d", len(cache.entries))
+		}
+	}
+}
+
+func TestSettingsCacheWithCustomCapacity(t *testing.T) {
+	cache := settingsCache{capacity: settingsCacheSize * 4}
+
+	for i := 0; i < settingsCacheSize*2; i++ {
+		if _, err := cache.get([]byte(fmt.Sprintf(`{"denied_labels": ["label-%d"]}`, i))); err != nil {
+			t.Fatalf("Unexpected error: %+v", err)
+		}
+	}
+	if len(cache.entries) != settingsCacheSize*2 {
+		t.Errorf("Unexpected number of cached documents: %d", len(cache.entries))
+	}
+}
+
+// Builds a validation request for an object with `labels` labels, plus
+// settings made of `rules` rules of each type
+func benchmarkRequest(b *testing.B, labels, rules int) []byte {
+	b.Helper()
+
+	objectLabels := make(map[string]string, labels)
+	for i := 0; i < labels; i++ {
+		objectLabels[fmt.Sprintf("label-%d", i)] = fmt.Sprintf("team-%d", i)
+	}
+	object := map[string]interface{}{
+		"kind":     "Pod",
+		"metadata": map[string]interface{}{"name": "web", "labels": objectLabels},
+	}
+
+	ruleList := []string{}
+	for i := 0; i < rules; i++ {
+		ruleList = append(ruleList,
+			fmt.Sprintf(`{"type": "denied", "key": "denied-%d"}`, i),
+			fmt.Sprintf(`{"type": "constrained", "key": "label-%d", "pattern": "^team-[0-9]+$"}`, i),
+		)
+		if i < labels {
+			ruleList = append(ruleList, fmt.Sprintf(`{"type": "mandatory", "key": "label-%d"}`, i))
+		}
+	}
+	settings, err := NewSettingsFromValidateSettingsPayload([]byte(
+		`{"version": 2, "rules": [` + strings.Join(ruleList, ",") + `]}`))
+	if err != nil {
+		b.Fatalf("Unexpected error: %+v", err)
+	}
+
+	payload, err := kubewarden_testing.BuildValidationRequest(object, &settings)
+	if err != nil {
+		b.Fatalf("Unexpected error: %+v", err)
+	}
+	return payload
+}
+
+func benchmarkValidate(b *testing.B, labels, rules int, cached bool) {
+	payload := benchmarkRequest(b, labels, rules)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !cached {
+			parsedSettings.reset()
+		}
+		if _, err := validate(payload); err != nil {
+			b.Fatalf("Unexpected error: %+v", err)
+		}
+	}
+}
+
+func BenchmarkValidate(b *testing.B) {
+	for _, size := range []struct{ labels, rules int }{
+		{10, 10},
+		{100, 100},
+		{500, 1000},
+	} {
+		for _, cached := range []bool{false, true} {
+			name := fmt.Sprintf("labels=%d/rules=%d/cached=%t", size.labels, size.rules, cached)
+			b.Run(name, func(b *testing.B) {
+				benchmarkValidate(b, size.labels, size.rules, cached)
+			})
+		}
+	}
+}
+
+func BenchmarkDecodeSettings(b *testing.B) {
+	payload := benchmarkRequest(b, 100, 1000)
+	raw := []byte(extractSettings(b, payload))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewSettingsFromValidateSettingsPayload(raw); err != nil {
+			b.Fatalf("Unexpected error: %+v", err)
+		}
+	}
+}
+
+func extractSettings(b *testing.B, payload []byte) string {
+	request := struct {
+		Settings json.RawMessage `json:"settings"`
+	}{}
+	if err := json.Unmarshal(payload, &request); err != nil {
+		b.Fatalf("Unexpected error: %+v", err)
+	}
+	return string(request.Settings)
+}
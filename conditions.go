@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// LabelCondition applies some requirements to the objects whose labels
+// satisfy a condition:
+//
+//	{
+//	  "when": { "key": "exposure", "allowed_values": ["public"] },
+//	  "then": { "mandatory": ["security-review"] }
+//	}
+type LabelCondition struct {
+	When ConditionClause       `json:"when"`
+	Then ConditionRequirements `json:"then"`
+}
+
+// ConditionClause is satisfied when a label is present, when it is absent
+// or when its value matches a pattern or a list of allowed values
+type ConditionClause struct {
+	Key           string             `json:"key"`
+	Present       bool               `json:"present,omitempty"`
+	Absent        bool               `json:"absent,omitempty"`
+	Pattern       *RegularExpression `json:"pattern,omitempty"`
+	AllowedValues []string           `json:"allowed_values,omitempty"`
+}
+
+func (c ConditionClause) MarshalJSON() ([]byte, error) {
+	type plainClause ConditionClause
+	plain := plainClause(c)
+	if plain.AllowedValues != nil {
+		plain.Pattern = nil
+	}
+	return json.Marshal(plain)
+}
+
+// Returns true when the labels satisfy the clause
+func (c ConditionClause) matches(labelValues map[string]string) bool {
+	value, found := labelValues[c.Key]
+	switch {
+	case c.Present:
+		return found
+	case c.Absent:
+		return !found
+	default:
+		return found && c.Pattern.MatchString(value)
+	}
+}
+
+// Describes the clause, like "exposure=public" or "tier is set"
+func (c ConditionClause) String() string {
+	switch {
+	case c.Present:
+		return c.Key + " is set"
+	case c.Absent:
+		return c.Key + " is not set"
+	case len(c.AllowedValues) == 1:
+		return c.Key + "=" + c.AllowedValues[0]
+	case c.AllowedValues != nil:
+		return fmt.Sprintf("%s is one of %s", c.Key, strings.Join(c.AllowedValues, ", "))
+	default:
+		return fmt.Sprintf("%s matches %s", c.Key, c.Pattern)
+	}
+}
+
+// ConditionRequirements are enforced when the condition is satisfied
+type ConditionRequirements struct {
+	Mandatory   []string             `json:"mandatory,omitempty"`
+	Denied      []string             `json:"denied,omitempty"`
+	Constrained *ConditionConstraint `json:"constrained,omitempty"`
+}
+
+// ConditionConstraint constrains the value of a label, when it is set
+type ConditionConstraint struct {
+	Key           string             `json:"key"`
+	Pattern       *RegularExpression `json:"pattern,omitempty"`
+	AllowedValues []string           `json:"allowed_values,omitempty"`
+}
+
+func (c ConditionConstraint) MarshalJSON() ([]byte, error) {
+	type plainConstraint ConditionConstraint
+	plain := plainConstraint(c)
+	if plain.AllowedValues != nil {
+		plain.Pattern = nil
+	}
+	return json.Marshal(plain)
+}
+
+// Returns the labels that must be allowed by a non exclusive allowlist
+func (r ConditionRequirements) keys() []string {
+	keys := slices.Clone(r.Mandatory)
+	if r.Constrained != nil {
+		keys = append(keys, r.Constrained.Key)
+	}
+	return keys
+}
+
+// Builds the messages describing the requirements of the satisfied
+// conditions that are violated, each one naming its condition. The
+// violated labels are returned too
+func (s *Settings) conditionViolationMessages(
+	labelValues map[string]string,
+	reqCtx requestContext,
+) ([]string, []string) {
+	msgs := []string{}
+	violations := []string{}
+
+	report := func(format string, condition ConditionClause, labels []string) {
+		if len(labels) == 0 {
+			return
+		}
+		// the description of the condition can hold a pattern
+		when := strings.ReplaceAll(condition.String(), "%", "%%")
+		msgs = append(msgs, s.violationMessages(
+			ConditionsRule,
+			fmt.Sprintf(format, when),
+			labels,
+			labelValues,
+			reqCtx)...)
+		violations = append(violations, labels...)
+	}
+
+	for _, condition := range s.Conditions {
+		if !condition.When.matches(labelValues) {
+			continue
+		}
+
+		missing := []string{}
+		for _, label := range condition.Then.Mandatory {
+			if _, found := labelValues[label]; !found {
+				missing = append(missing, label)
+			}
+		}
+		report("The following labels are mandatory when %s: %%s", condition.When, missing)
+
+		denied := []string{}
+		for _, label := range condition.Then.Denied {
+			if _, found := labelValues[label]; found {
+				denied = append(denied, label)
+			}
+		}
+		report("The following labels are denied when %s: %%s", condition.When, denied)
+
+		if constraint := condition.Then.Constrained; constraint != nil {
+			value, found := labelValues[constraint.Key]
+			if found && !constraint.Pattern.MatchString(value) {
+				report("The following labels are violating the constraints applying when %s: %%s",
+					condition.When, []string{constraint.Key})
+			}
+		}
+	}
+	return msgs, violations
+}
+
+// Checks the requirements of the conditions against each other and
+// against the denied rules, returns a list of problems
+func (s *Settings) validateConditions() []string {
+	errors := []string{}
+	for i, condition := range s.Conditions {
+		for _, label := range condition.Then.Mandatory {
+			if _, denied := s.deniedRule(label); denied {
+				errors = append(errors, fmt.Sprintf(
+					"condition %d makes mandatory the label %s, which is denied", i, label))
+			}
+			if slices.Contains(condition.Then.Denied, label) {
+				errors = append(errors, fmt.Sprintf(
+					"condition %d cannot make label %s mandatory and denied at the same time", i, label))
+			}
+			if s.AllowedLabels != nil && s.AllowedLabels.Exclusive && s.notAllowed(label) {
+				errors = append(errors, fmt.Sprintf(
+					"condition %d makes mandatory the label %s, which is not allowed by allowed_labels", i, label))
+			}
+		}
+		for _, label := range condition.Then.Denied {
+			if s.MandatoryLabels.Contains(label) {
+				errors = append(errors, fmt.Sprintf(
+					"condition %d denies the label %s, which is mandatory", i, label))
+			}
+		}
+	}
+	return errors
+}
+
+// Decodes a condition
+func (d *settingsDecoder) labelCondition(path string, raw json.RawMessage) (LabelCondition, bool) {
+	condition := LabelCondition{}
+	errsBefore := len(d.errs)
+
+	d.object(path, raw, fieldDecoders{
+		"when": func(path string, raw json.RawMessage) {
+			condition.When, _ = d.conditionClause(path, raw)
+		},
+		"then": func(path string, raw json.RawMessage) {
+			condition.Then, _ = d.conditionRequirements(path, raw)
+		},
+	})
+	if len(d.errs) != errsBefore {
+		return condition, false
+	}
+
+	switch {
+	case condition.When.Key == "":
+		d.fail(path, "missing required field when")
+	case len(condition.Then.keys()) == 0 && len(condition.Then.Denied) == 0:
+		d.fail(path, "missing required field then")
+	}
+	return condition, len(d.errs) == errsBefore
+}
+
+func (d *settingsDecoder) conditionClause(path string, raw json.RawMessage) (ConditionClause, bool) {
+	clause := ConditionClause{}
+	errsBefore := len(d.errs)
+
+	fields := d.constraintFields(&clause.Pattern, &clause.AllowedValues)
+	fields["key"] = func(path string, raw json.RawMessage) {
+		if key, ok := d.string(path, raw); ok && d.labelKey(path, key) {
+			clause.Key = key
+		}
+	}
+	fields["present"] = func(path string, raw json.RawMessage) {
+		clause.Present, _ = d.bool(path, raw)
+	}
+	fields["absent"] = func(path string, raw json.RawMessage) {
+		clause.Absent, _ = d.bool(path, raw)
+	}
+	d.object(path, raw, fields)
+	if len(d.errs) != errsBefore {
+		return clause, false
+	}
+
+	tests := 0
+	for _, set := range []bool{clause.Present, clause.Absent, clause.Pattern != nil, clause.AllowedValues != nil} {
+		if set {
+			tests++
+		}
+	}
+	switch {
+	case clause.Key == "":
+		d.fail(path, "missing required field key")
+	case tests != 1:
+		d.fail(path, "needs exactly one of present, absent, pattern and allowed_values")
+	case clause.AllowedValues != nil:
+		clause.Pattern = allowedValuesRegularExpression(clause.AllowedValues)
+	}
+	return clause, len(d.errs) == errsBefore
+}
+
+func (d *settingsDecoder) conditionRequirements(path string, raw json.RawMessage) (ConditionRequirements, bool) {
+	requirements := ConditionRequirements{}
+	errsBefore := len(d.errs)
+
+	labels := func(labels *[]string) fieldDecoder {
+		return func(path string, raw json.RawMessage) {
+			d.array(path, raw, func(path string, raw json.RawMessage) {
+				if key, ok := d.string(path, raw); ok && d.labelKey(path, key) {
+					*labels = append(*labels, key)
+				}
+			})
+		}
+	}
+
+	d.object(path, raw, fieldDecoders{
+		"mandatory": labels(&requirements.Mandatory),
+		"denied":    labels(&requirements.Denied),
+		"constrained": func(path string, raw json.RawMessage) {
+			constraint := &ConditionConstraint{}
+			errsBefore := len(d.errs)
+			fields := d.constraintFields(&constraint.Pattern, &constraint.AllowedValues)
+			fields["key"] = func(path string, raw json.RawMessage) {
+				if key, ok := d.string(path, raw); ok && d.labelKey(path, key) {
+					constraint.Key = key
+				}
+			}
+			if !d.object(path, raw, fields) || len(d.errs) != errsBefore {
+				return
+			}
+			if constraint.Key == "" {
+				d.fail(path, "missing required field key")
+				return
+			}
+			if d.completeConstraint(path, &constraint.Pattern, constraint.AllowedValues) {
+				requirements.Constrained = constraint
+			}
+		},
+	})
+	if len(d.errs) == errsBefore && len(requirements.keys()) == 0 && len(requirements.Denied) == 0 {
+		d.fail(path, "needs at least one of mandatory, denied and constrained")
+	}
+	return requirements, len(d.errs) == errsBefore
+}
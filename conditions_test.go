@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+	kubewarden_testing "github.com/kubewarden/policy-sdk-go/testing"
+)
+
+func TestConditions(t *testing.T) {
+	cases := []struct {
+		name             string
+		conditions       string
+		expectedAccepted bool
+		expectedMessage  string
+	}{
+		{
+			name: "mandatory label required by a value",
+			conditions: `[ {
+				"when": { "key": "environment", "allowed_values": [ "prdo" ] },
+				"then": { "mandatory": [ "owner" ] }
+			} ]`,
+			expectedMessage: "The following labels are mandatory when environment=prdo: owner",
+		},
+		{
+			name: "mandatory label required by a present label",
+			conditions: `[ {
+				"when": { "key": "app", "present": true },
+				"then": { "mandatory": [ "ownr" ] }
+			} ]`,
+			expectedAccepted: true,
+		},
+		{
+			name: "condition not satisfied",
+			conditions: `[ {
+				"when": { "key": "tier", "present": true },
+				"then": { "mandatory": [ "tier-owner" ] }
+			} ]`,
+			expectedAccepted: true,
+		},
+		{
+			name: "label denied by an absent label",
+			conditions: `[ {
+				"when": { "key": "owner", "absent": true },
+				"then": { "denied": [ "ownr" ] }
+			} ]`,
+			expectedMessage: "The following labels are denied when owner is not set: ownr",
+		},
+		{
+			name: "value constrained by a matching value",
+			conditions: `[ {
+				"when": { "key": "app", "pattern": "^w" },
+				"then": { "constrained": { "key": "environment", "allowed_values": [ "prod", "dev" ] } }
+			} ]`,
+			expectedMessage: "The following labels are violating the constraints applying when app matches ^w: environment",
+		},
+		{
+			name: "multiple conditions satisfied",
+			conditions: `[
+				{
+					"when": { "key": "environment", "allowed_values": [ "prod", "prdo" ] },
+					"then": { "mandatory": [ "owner", "security-review" ] }
+				},
+				{
+					"when": { "key": "app", "present": true },
+					"then": { "denied": [ "environment" ] }
+				}
+			]`,
+			expectedMessage: "The following labels are mandatory when environment is one of prod, prdo: owner,security-review. " +
+				"The following labels are denied when app is set: environment",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			settings, err := NewSettingsFromValidateSettingsPayload([]byte(`
+			{
+				"version": 2,
+				"conditions": ` + tc.conditions + `
+			}`))
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+			if valid, err := settings.Valid(); !valid {
+				t.Fatalf("Expected settings to be valid: %v", err)
+			}
+
+			payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+				"test_data/pod.json",
+				&settings)
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			responsePayload, err := validate(payload)
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			var response kubewarden_protocol.ValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			if response.Accepted != tc.expectedAccepted {
+				t.Fatalf("Unexpected response: %+v", response)
+			}
+			if tc.expectedAccepted {
+				return
+			}
+			if *response.Message != tc.expectedMessage {
+				t.Errorf("Unexpected rejection message: %s", *response.Message)
+			}
+		})
+	}
+}
+
+func TestDetectNotValidConditions(t *testing.T) {
+	cases := []struct {
+		name            string
+		settings        string
+		expectedMessage string
+	}{
+		{
+			name: "more tests inside of the same clause",
+			settings: `"conditions": [ {
+				"when": { "key": "tier", "present": true, "pattern": "^web$" },
+				"then": { "mandatory": [ "app" ] }
+			} ]`,
+			expectedMessage: "/conditions/0/when: needs exactly one of present, absent, pattern and allowed_values",
+		},
+		{
+			name: "no requirements",
+			settings: `"conditions": [ {
+				"when": { "key": "tier", "present": true },
+				"then": {}
+			} ]`,
+			expectedMessage: "/conditions/0/then: needs at least one of mandatory, denied and constrained",
+		},
+		{
+			name: "constraint without pattern",
+			settings: `"conditions": [ {
+				"when": { "key": "tier", "present": true },
+				"then": { "constrained": { "key": "app" } }
+			} ]`,
+			expectedMessage: "/conditions/0/then/constrained: needs either a pattern or allowed_values",
+		},
+		{
+			name: "mandatory label denied by the rules",
+			settings: `"conditions": [ {
+				"when": { "key": "tier", "present": true },
+				"then": { "mandatory": [ "debug" ] }
+			} ],
+			"rules": [ { "type": "denied", "key": "debug" } ]`,
+			expectedMessage: "condition 0 makes mandatory the label debug, which is denied",
+		},
+		{
+			name: "label both mandatory and denied",
+			settings: `"conditions": [ {
+				"when": { "key": "tier", "present": true },
+				"then": { "mandatory": [ "app" ], "denied": [ "app" ] }
+			} ]`,
+			expectedMessage: "condition 0 cannot make label app mandatory and denied at the same time",
+		},
+		{
+			name: "label denied by a condition and mandatory for the rules",
+			settings: `"conditions": [ {
+				"when": { "key": "tier", "present": true },
+				"then": { "denied": [ "owner" ] }
+			} ],
+			"rules": [ { "type": "mandatory", "key": "owner" } ]`,
+			expectedMessage: "condition 0 denies the label owner, which is mandatory",
+		},
+		{
+			name: "mandatory label not allowed by an exclusive allowlist",
+			settings: `"conditions": [ {
+				"when": { "key": "tier", "present": true },
+				"then": { "mandatory": [ "security-review" ] }
+			} ],
+			"allowed_labels": { "keys": [ "tier" ], "exclusive": true }`,
+			expectedMessage: "condition 0 makes mandatory the label security-review, which is not allowed by allowed_labels",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			responsePayload, err := validateSettings([]byte(`
+			{
+				"version": 2,
+				` + tc.settings + `
+			}`))
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			var response kubewarden_protocol.SettingsValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			if response.Valid {
+				t.Fatal("Expected settings to not be valid")
+			}
+			if !strings.Contains(*response.Message, tc.expectedMessage) {
+				t.Errorf("Unexpected message: %s", *response.Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/kubewarden/gjson"
+	"github.com/kubewarden/policy-sdk-go/pkg/capabilities/kubernetes"
+)
+
+// The formats of the values held by the key of a ConfigMap
+const (
+	// One allowed value per line, blank lines are ignored
+	ConfigMapAllowedValues = "allowed_values"
+	// A regular expression
+	ConfigMapPattern = "pattern"
+)
+
+// What to do when the constraint cannot be read from the ConfigMap
+const (
+	// Reject the objects setting the label
+	ConfigMapFailClosed = "fail_closed"
+	// Accept any value of the label
+	ConfigMapFailOpen = "fail_open"
+)
+
+var (
+	configMapFormats   = []string{ConfigMapAllowedValues, ConfigMapPattern}
+	configMapFallbacks = []string{ConfigMapFailClosed, ConfigMapFailOpen}
+
+	namespaceNameRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+	configMapKeyRegexp  = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+)
+
+// ConfigMapSource points to the key of a ConfigMap holding the constraint
+// of a label, which can change without redeploying the settings:
+//
+//	{
+//	  "namespace": "finance",
+//	  "name": "cost-centers",
+//	  "key": "values",
+//	  "format": "allowed_values",
+//	  "fallback": "fail_closed"
+//	}
+type ConfigMapSource struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Key       string `json:"key"`
+	// One of `configMapFormats`, defaults to ConfigMapAllowedValues
+	Format string `json:"format,omitempty"`
+	// One of `configMapFallbacks`, defaults to ConfigMapFailClosed
+	Fallback string `json:"fallback,omitempty"`
+}
+
+func (c ConfigMapSource) String() string {
+	return fmt.Sprintf("%s/%s", c.Namespace, c.Name)
+}
+
+func (c ConfigMapSource) failOpen() bool {
+	return c.Fallback == ConfigMapFailOpen
+}
+
+// Builds the constraint out of the value of the ConfigMap key
+func (c ConfigMapSource) constraint(value string, limits Limits) (*RegularExpression, error) {
+	if c.Format == ConfigMapPattern {
+		pattern := strings.TrimSpace(value)
+		if length := len(pattern); length > limits.maxPatternLength() {
+			return nil, fmt.Errorf("the pattern is %d characters long, the limit is %d", length, limits.maxPatternLength())
+		}
+		re, err := CompileRegularExpression(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %v", err)
+		}
+		if size := programSize(re); size > limits.maxProgramSize() {
+			return nil, fmt.Errorf("the pattern compiles to %d instructions, the limit is %d", size, limits.maxProgramSize())
+		}
+		return re, nil
+	}
+
+	values := []string{}
+	for _, line := range strings.Split(value, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			values = append(values, line)
+		}
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("key %s holds no allowed values", c.Key)
+	}
+	return allowedValuesRegularExpression(values), nil
+}
+
+// Looks up the ConfigMaps through the policy host. Each ConfigMap is
+// fetched, and each constraint is built, at most once per admission request
+type configMapLookup struct {
+	constraints map[ConfigMapSource]*RegularExpression
+	errors      map[ConfigMapSource]error
+	data        map[string]map[string]string
+}
+
+func newConfigMapLookup() *configMapLookup {
+	return &configMapLookup{
+		constraints: make(map[ConfigMapSource]*RegularExpression),
+		errors:      make(map[ConfigMapSource]error),
+		data:        make(map[string]map[string]string),
+	}
+}
+
+// Returns the constraint read from the ConfigMap
+func (l *configMapLookup) constraint(source ConfigMapSource, limits Limits) (*RegularExpression, error) {
+	if re, found := l.constraints[source]; found {
+		return re, nil
+	}
+	if err, found := l.errors[source]; found {
+		return nil, err
+	}
+
+	re, err := l.readConstraint(source, limits)
+	if err != nil {
+		err = fmt.Errorf("ConfigMap %s: %w", source, err)
+		l.errors[source] = err
+		return nil, err
+	}
+	l.constraints[source] = re
+	return re, nil
+}
+
+func (l *configMapLookup) readConstraint(source ConfigMapSource, limits Limits) (*RegularExpression, error) {
+	data, found := l.data[source.String()]
+	if !found {
+		namespace := source.Namespace
+		response, err := kubernetes.GetResource(&host, kubernetes.GetResourceRequest{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+			Name:       source.Name,
+			Namespace:  &namespace,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("cannot look it up: %w", err)
+		}
+		if !gjson.ValidBytes(response) {
+			return nil, fmt.Errorf("the host returned a malformed object")
+		}
+		data = stringMap(gjson.GetBytes(response, "data"))
+		l.data[source.String()] = data
+	}
+
+	value, found := data[source.Key]
+	if !found {
+		return nil, fmt.Errorf("there is no key %s", source.Key)
+	}
+	return source.constraint(value, limits)
+}
+
+// Returns the labels whose constraint is read from a ConfigMap, sorted
+func (s *Settings) configMapConstrainedLabels() []string {
+	labels := make([]string, 0, len(s.ConfigMapConstraints))
+	for label := range s.ConfigMapConstraints {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// Checks the constraints read from ConfigMaps, they need context-aware
+// lookups
+func (s *Settings) validateConfigMapConstraints() []string {
+	errors := []string{}
+	if len(s.ConfigMapConstraints) > 0 && !s.ContextAware {
+		errors = append(errors, fmt.Sprintf(
+			"constrained labels %s read their constraint from a ConfigMap, which requires context_aware to be enabled",
+			strings.Join(s.configMapConstrainedLabels(), ",")))
+	}
+	return errors
+}
+
+// Decodes the reference to a ConfigMap
+func (d *settingsDecoder) configMapSource(path string, raw json.RawMessage) (*ConfigMapSource, bool) {
+	source := ConfigMapSource{}
+	errsBefore := len(d.errs)
+
+	name := func(value *string, valid *regexp.Regexp, maxLength int, kind string) fieldDecoder {
+		return func(path string, raw json.RawMessage) {
+			if name, ok := d.string(path, raw); ok {
+				if len(name) > maxLength || !valid.MatchString(name) {
+					d.fail(path, "%q is not a valid %s", name, kind)
+					return
+				}
+				*value = name
+			}
+		}
+	}
+	oneOf := func(value *string, allowed []string) fieldDecoder {
+		return func(path string, raw json.RawMessage) {
+			if v, ok := d.string(path, raw); ok {
+				if !slices.Contains(allowed, v) {
+					d.fail(path, "must be one of: %s", strings.Join(allowed, ", "))
+					return
+				}
+				*value = v
+			}
+		}
+	}
+
+	d.object(path, raw, fieldDecoders{
+		"namespace": name(&source.Namespace, namespaceNameRegexp, labelNameMaxLength, "Namespace name"),
+		"name":      name(&source.Name, labelPrefixRegexp, labelPrefixMaxLength, "ConfigMap name"),
+		"key":       name(&source.Key, configMapKeyRegexp, labelPrefixMaxLength, "ConfigMap key"),
+		"format":    oneOf(&source.Format, configMapFormats),
+		"fallback":  oneOf(&source.Fallback, configMapFallbacks),
+	})
+	if len(d.errs) != errsBefore {
+		return nil, false
+	}
+
+	for _, field := range []struct{ name, value string }{
+		{"namespace", source.Namespace},
+		{"name", source.Name},
+		{"key", source.Key},
+	} {
+		if field.value == "" {
+			d.fail(path, "missing required field %s", field.name)
+			return nil, false
+		}
+	}
+	return &source, true
+}
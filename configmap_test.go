@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+	kubewarden_testing "github.com/kubewarden/policy-sdk-go/testing"
+)
+
+func TestConfigMapConstraints(t *testing.T) {
+	cases := []struct {
+		name             string
+		source           string
+		configMap        string
+		expectedAccepted bool
+		expectedMessage  string
+	}{
+		{
+			name:             "allowed value",
+			source:           `{ "namespace": "finance", "name": "environments", "key": "values" }`,
+			configMap:        `{"data": {"values": "prod\nprdo\n\n"}}`,
+			expectedAccepted: true,
+		},
+		{
+			name:            "not allowed value",
+			source:          `{ "namespace": "finance", "name": "environments", "key": "values" }`,
+			configMap:       `{"data": {"values": "prod\nstaging"}}`,
+			expectedMessage: "The following labels are violating user constraints: environment",
+		},
+		{
+			name:             "matching pattern",
+			source:           `{ "namespace": "finance", "name": "environments", "key": "pattern", "format": "pattern" }`,
+			configMap:        `{"data": {"pattern": "^pr"}}`,
+			expectedAccepted: true,
+		},
+		{
+			name:            "not valid pattern",
+			source:          `{ "namespace": "finance", "name": "environments", "key": "pattern", "format": "pattern" }`,
+			configMap:       `{"data": {"pattern": "("}}`,
+			expectedMessage: "The constraints of the following labels cannot be read: environment (ConfigMap finance/environments: invalid regex: ",
+		},
+		{
+			name:   "missing ConfigMap, failing closed",
+			source: `{ "namespace": "finance", "name": "environments", "key": "values" }`,
+			expectedMessage: "The constraints of the following labels cannot be read: " +
+				"environment (ConfigMap finance/environments: cannot look it up: ",
+		},
+		{
+			name:             "missing key, failing open",
+			source:           `{ "namespace": "finance", "name": "environments", "key": "values", "fallback": "fail_open" }`,
+			configMap:        `{"data": {"other": "prod"}}`,
+			expectedAccepted: true,
+		},
+		{
+			name:            "missing key, failing closed",
+			source:          `{ "namespace": "finance", "name": "environments", "key": "values", "fallback": "fail_closed" }`,
+			configMap:       `{"data": {"other": "prod"}}`,
+			expectedMessage: "The constraints of the following labels cannot be read: environment (ConfigMap finance/environments: there is no key values)",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			objects := map[string]string{}
+			if tc.configMap != "" {
+				objects[objectKey("ConfigMap", "finance", "environments")] = tc.configMap
+			}
+			useFakeCluster(t, &fakeCluster{objects: objects})
+
+			settings, err := NewSettingsFromValidateSettingsPayload([]byte(`
+			{
+				"version": 2,
+				"context_aware": true,
+				"rules": [ { "type": "constrained", "key": "environment", "config_map": ` + tc.source + ` } ]
+			}`))
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+			if valid, err := settings.Valid(); !valid {
+				t.Fatalf("Expected settings to be valid: %v", err)
+			}
+
+			payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+				"test_data/pod.json",
+				&settings)
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			responsePayload, err := validate(payload)
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			var response kubewarden_protocol.ValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			if response.Accepted != tc.expectedAccepted {
+				t.Fatalf("Unexpected response: %+v", response)
+			}
+			if tc.expectedAccepted {
+				return
+			}
+			if !strings.HasPrefix(*response.Message, tc.expectedMessage) {
+				t.Errorf("Unexpected rejection message: %s", *response.Message)
+			}
+		})
+	}
+}
+
+func TestConfigMapLookupFetchesEachConfigMapOnce(t *testing.T) {
+	cluster := &fakeCluster{objects: map[string]string{
+		objectKey("ConfigMap", "finance", "labels"): `{"data": {"environments": "prdo", "apps": "web"}}`,
+	}}
+	useFakeCluster(t, cluster)
+
+	settings, err := NewSettingsFromValidateSettingsPayload([]byte(`
+	{
+		"version": 2,
+		"context_aware": true,
+		"rules": [
+			{
+				"type": "constrained",
+				"key": "environment",
+				"config_map": { "namespace": "finance", "name": "labels", "key": "environments" }
+			},
+			{
+				"type": "constrained",
+				"key": "app",
+				"config_map": { "namespace": "finance", "name": "labels", "key": "apps" }
+			}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+		"test_data/pod.json",
+		&settings)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	responsePayload, err := validate(payload)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	var response kubewarden_protocol.ValidationResponse
+	if err := json.Unmarshal(responsePayload, &response); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	if !response.Accepted {
+		t.Errorf("Unexpected rejection: %s", *response.Message)
+	}
+	if len(cluster.calls) != 1 {
+		t.Errorf("Expected a single host call, got: %v", cluster.calls)
+	}
+}
+
+func TestDetectNotValidConfigMapSources(t *testing.T) {
+	cases := []struct {
+		name            string
+		rule            string
+		expectedMessage string
+	}{
+		{
+			name:            "not valid namespace",
+			rule:            `"config_map": { "namespace": "Finance", "name": "labels", "key": "values" }`,
+			expectedMessage: `/rules/0/config_map/namespace: "Finance" is not a valid Namespace name`,
+		},
+		{
+			name:            "not valid key",
+			rule:            `"config_map": { "namespace": "finance", "name": "labels", "key": "a/b" }`,
+			expectedMessage: `/rules/0/config_map/key: "a/b" is not a valid ConfigMap key`,
+		},
+		{
+			name:            "missing key",
+			rule:            `"config_map": { "namespace": "finance", "name": "labels" }`,
+			expectedMessage: "/rules/0/config_map: missing required field key",
+		},
+		{
+			name:            "unknown format",
+			rule:            `"config_map": { "namespace": "finance", "name": "labels", "key": "values", "format": "csv" }`,
+			expectedMessage: "/rules/0/config_map/format: must be one of: allowed_values, pattern",
+		},
+		{
+			name:            "unknown fallback",
+			rule:            `"config_map": { "namespace": "finance", "name": "labels", "key": "values", "fallback": "ignore" }`,
+			expectedMessage: "/rules/0/config_map/fallback: must be one of: fail_closed, fail_open",
+		},
+		{
+			name: "both a pattern and a ConfigMap",
+			rule: `"pattern": "^prod$",
+				"config_map": { "namespace": "finance", "name": "labels", "key": "values" }`,
+			expectedMessage: "/rules/0: cannot have config_map together with pattern or allowed_values",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			responsePayload, err := validateSettings([]byte(`
+			{
+				"version": 2,
+				"context_aware": true,
+				"rules": [ { "type": "constrained", "key": "environment", ` + tc.rule + ` } ]
+			}`))
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			var response kubewarden_protocol.SettingsValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			if response.Valid {
+				t.Fatal("Expected settings to not be valid")
+			}
+			if !strings.Contains(*response.Message, tc.expectedMessage) {
+				t.Errorf("Unexpected message: %s", *response.Message)
+			}
+		})
+	}
+}
+
+func TestConfigMapConstraintsRequireContextAwareLookups(t *testing.T) {
+	settings, err := NewSettingsFromValidateSettingsPayload([]byte(`
+	{
+		"version": 2,
+		"rules": [
+			{
+				"type": "constrained",
+				"key": "environment",
+				"config_map": { "namespace": "finance", "name": "labels", "key": "values" }
+			}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	valid, err := settings.Valid()
+	if valid {
+		t.Fatal("Expected settings to not be valid")
+	}
+	expected := "constrained labels environment read their constraint from a ConfigMap, which requires context_aware to be enabled"
+	if err.Error() != expected {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/kubewarden/gjson"
+	"github.com/kubewarden/policy-sdk-go/pkg/capabilities"
+	"github.com/kubewarden/policy-sdk-go/pkg/capabilities/kubernetes"
+)
+
+// The policy host, used to look up Kubernetes objects when context-aware
+// lookups are enabled. Tests replace its client with a fake one
+var host = capabilities.NewHost()
+
+// A Namespace, as seen by the context-aware lookups
+type namespaceObject struct {
+	name        string
+	labels      map[string]string
+	annotations map[string]string
+}
+
+// Looks up the Namespace objects through the policy host. Each Namespace
+// is fetched at most once per admission request
+type namespaceLookup struct {
+	namespaces map[string]*namespaceObject
+}
+
+func newNamespaceLookup() *namespaceLookup {
+	return &namespaceLookup{namespaces: make(map[string]*namespaceObject)}
+}
+
+func (l *namespaceLookup) get(name string) (*namespaceObject, error) {
+	if ns, found := l.namespaces[name]; found {
+		return ns, nil
+	}
+
+	response, err := kubernetes.GetResource(&host, kubernetes.GetResourceRequest{
+		APIVersion: "v1",
+		Kind:       "Namespace",
+		Name:       name,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot look up Namespace %s: %w", name, err)
+	}
+	if !gjson.ValidBytes(response) {
+		return nil, fmt.Errorf("cannot look up Namespace %s: the host returned a malformed object", name)
+	}
+
+	ns := &namespaceObject{
+		name:        name,
+		labels:      stringMap(gjson.GetBytes(response, "metadata.labels")),
+		annotations: stringMap(gjson.GetBytes(response, "metadata.annotations")),
+	}
+	l.namespaces[name] = ns
+	return ns, nil
+}
+
+// Converts a JSON object holding string values into a map
+func stringMap(data gjson.Result) map[string]string {
+	values := make(map[string]string)
+	data.ForEach(func(key, value gjson.Result) bool {
+		values[key.String()] = value.String()
+		return true
+	})
+	return values
+}
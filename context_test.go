@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/kubewarden/gjson"
+	"github.com/kubewarden/policy-sdk-go/pkg/capabilities/kubernetes"
+)
+
+// A fake policy host that answers the Kubernetes lookups using a set of
+// objects, indexed by kind, namespace and name
+type fakeCluster struct {
+	objects map[string]string
+	// The host calls received, in order
+	calls []string
+}
+
+func objectKey(kind, namespace, name string) string {
+	return fmt.Sprintf("%s/%s/%s", kind, namespace, name)
+}
+
+func (c *fakeCluster) HostCall(binding, namespace, operation string, payload []byte) ([]byte, error) {
+	c.calls = append(c.calls, operation)
+
+	switch operation {
+	case "get_resource":
+		req := kubernetes.GetResourceRequest{}
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return nil, err
+		}
+		ns := ""
+		if req.Namespace != nil {
+			ns = *req.Namespace
+		}
+		object, found := c.objects[objectKey(req.Kind, ns, req.Name)]
+		if !found {
+			return nil, fmt.Errorf("%s %s not found", req.Kind, req.Name)
+		}
+		return []byte(object), nil
+	case "list_resources_all":
+		req := kubernetes.ListAllResourcesRequest{}
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return nil, err
+		}
+		return c.list(req.Kind, nil, req.LabelSelector, req.FieldSelector), nil
+	case "list_resources_by_namespace":
+		req := kubernetes.ListResourcesByNamespaceRequest{}
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return nil, err
+		}
+		return c.list(req.Kind, &req.Namespace, req.LabelSelector, req.FieldSelector), nil
+	default:
+		return nil, fmt.Errorf("unexpected operation %s", operation)
+	}
+}
+
+// Lists the objects of the given kind, supporting only the selectors
+// made of a single `key=value` term. The field selectors can match just
+// the name of the objects
+func (c *fakeCluster) list(kind string, namespace, labelSelector, fieldSelector *string) []byte {
+	keys := make([]string, 0, len(c.objects))
+	for key := range c.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	items := []string{}
+	for _, key := range keys {
+		parts := strings.SplitN(key, "/", 3)
+		if parts[0] != kind || (namespace != nil && parts[1] != *namespace) {
+			continue
+		}
+		object := c.objects[key]
+		if fieldSelector != nil && *fieldSelector != "metadata.name="+parts[2] {
+			continue
+		}
+		if labelSelector != nil {
+			label, value, _ := strings.Cut(*labelSelector, "=")
+			actual, found := stringMap(gjson.Get(object, "metadata.labels"))[label]
+			if !found || actual != value {
+				continue
+			}
+		}
+		items = append(items, object)
+	}
+	return []byte(`{"items": [` + strings.Join(items, ",") + `]}`)
+}
+
+// Makes the policy host answer using the given fake cluster for the
+// duration of the test
+func useFakeCluster(t *testing.T, cluster *fakeCluster) {
+	previous := host.Client
+	host.Client = cluster
+	t.Cleanup(func() { host.Client = previous })
+}
+
+func TestNamespaceLookupFetchesEachNamespaceOnce(t *testing.T) {
+	cluster := &fakeCluster{objects: map[string]string{
+		objectKey("Namespace", "", "payments"): `{"metadata": {"name": "payments", "labels": {"env": "prod"}}}`,
+	}}
+	useFakeCluster(t, cluster)
+
+	lookup := newNamespaceLookup()
+	for i := 0; i < 2; i++ {
+		ns, err := lookup.get("payments")
+		if err != nil {
+			t.Fatalf("Unexpected error: %+v", err)
+		}
+		if ns.labels["env"] != "prod" {
+			t.Errorf("Unexpected labels: %v", ns.labels)
+		}
+	}
+	if len(cluster.calls) != 1 {
+		t.Errorf("Expected a single host call, got: %v", cluster.calls)
+	}
+
+	if _, err := lookup.get("missing"); err == nil {
+		t.Error("Expected an error")
+	}
+}
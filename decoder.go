@@ -0,0 +1,764 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+
+	mapset "github.com/deckarep/golang-set/v2"
+)
+
+// Decodes the value found at `path`
+type fieldDecoder func(path string, raw json.RawMessage)
+
+// The decoders of the fields of an object, indexed by field name
+type fieldDecoders map[string]fieldDecoder
+
+// settingsDecoder decodes the settings in a strict way: unknown fields
+// and values of the wrong type are rejected. Instead of stopping at the
+// first problem, all of them are collected together with the JSON pointer
+// of the offending value
+type settingsDecoder struct {
+	errs fieldErrors
+	// The values of the `definitions` section, indexed by name
+	definitions map[string]json.RawMessage
+	// The definitions that cannot be used, because they are malformed
+	// or part of a cycle
+	brokenDefinitions map[string]struct{}
+	// The definitions whose own references have been resolved already
+	resolvedDefinitions map[string]json.RawMessage
+	// The limits bounding the expansion of the references, read before
+	// decoding the rest of the document
+	expansionLimits Limits
+	// The array items and the bytes produced so far by expanding the
+	// references, see expand
+	expandedItems int
+	expandedSize  int
+	// Set once the expansion went beyond its limits, the references
+	// that follow are not expanded anymore
+	expansionExceeded bool
+}
+
+func (d *settingsDecoder) fail(path, format string, args ...interface{}) {
+	d.errs = append(d.errs, fieldError{path: path, message: fmt.Sprintf(format, args...)})
+}
+
+// Returns the JSON type of the given value
+func jsonKind(raw json.RawMessage) string {
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) == 0 {
+		return "nothing"
+	}
+	switch trimmed[0] {
+	case '{':
+		return "object"
+	case '[':
+		return "array"
+	case '"':
+		return "string"
+	case 't', 'f':
+		return "boolean"
+	case 'n':
+		return "null"
+	default:
+		return "number"
+	}
+}
+
+func (d *settingsDecoder) expect(path string, raw json.RawMessage, kind string) bool {
+	if actual := jsonKind(raw); actual != kind {
+		d.fail(path, "expected %s, got %s", withArticle(kind), withArticle(actual))
+		return false
+	}
+	return true
+}
+
+func withArticle(kind string) string {
+	switch kind {
+	case "null", "nothing":
+		return kind
+	case "object", "array":
+		return "an " + kind
+	default:
+		return "a " + kind
+	}
+}
+
+// Invokes `decode` for each entry of the object, following the order of
+// the document. Duplicated keys are reported as errors
+func (d *settingsDecoder) entries(
+	path string,
+	raw json.RawMessage,
+	decode func(path, key string, raw json.RawMessage),
+) bool {
+	if !d.expect(path, raw, "object") {
+		return false
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if _, err := dec.Token(); err != nil {
+		d.fail(path, "%v", err)
+		return false
+	}
+
+	seen := make(map[string]struct{})
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			d.fail(path, "%v", err)
+			return false
+		}
+		key, _ := token.(string)
+
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			d.fail(jsonPointer(path, key), "%v", err)
+			return false
+		}
+
+		if _, duplicated := seen[key]; duplicated {
+			d.fail(jsonPointer(path, key), "duplicated field")
+			continue
+		}
+		seen[key] = struct{}{}
+
+		value, ok := d.resolve(jsonPointer(path, key), value)
+		if !ok {
+			continue
+		}
+		decode(jsonPointer(path, key), key, value)
+	}
+
+	return true
+}
+
+// Decodes an object made of well-known fields. Unknown fields are
+// reported as errors, while null values are treated like missing fields
+func (d *settingsDecoder) object(path string, raw json.RawMessage, fields fieldDecoders) bool {
+	return d.entries(path, raw, func(fieldPath, key string, value json.RawMessage) {
+		decode, known := fields[key]
+		if !known {
+			names := make([]string, 0, len(fields))
+			for name := range fields {
+				names = append(names, name)
+			}
+			if suggestion, found := closestNearMiss(key, names); found {
+				d.fail(fieldPath, "unknown field, did you mean %q?", suggestion)
+			} else {
+				sort.Strings(names)
+				d.fail(fieldPath, "unknown field, known fields are: %s", strings.Join(names, ", "))
+			}
+			return
+		}
+		if jsonKind(value) == "null" {
+			return
+		}
+		decode(fieldPath, value)
+	})
+}
+
+func (d *settingsDecoder) array(path string, raw json.RawMessage, decode fieldDecoder) bool {
+	if !d.expect(path, raw, "array") {
+		return false
+	}
+
+	items := []json.RawMessage{}
+	if err := json.Unmarshal(raw, &items); err != nil {
+		d.fail(path, "%v", err)
+		return false
+	}
+	for i, item := range items {
+		d.arrayItem(jsonPointer(path, fmt.Sprint(i)), item, decode)
+	}
+
+	return true
+}
+
+// Decodes an item of an array. A reference to a definition holding an
+// array is replaced by the items of the definition
+func (d *settingsDecoder) arrayItem(path string, raw json.RawMessage, decode fieldDecoder) {
+	resolved, ok := d.resolve(path, raw)
+	if !ok {
+		return
+	}
+	if !isRef(raw) || jsonKind(resolved) != "array" {
+		decode(path, resolved)
+		return
+	}
+
+	items := []json.RawMessage{}
+	if err := json.Unmarshal(resolved, &items); err != nil {
+		d.fail(path, "%v", err)
+		return
+	}
+	for _, item := range items {
+		if !d.expand(path, 1, 0) {
+			return
+		}
+		d.arrayItem(path, item, decode)
+	}
+}
+
+func (d *settingsDecoder) string(path string, raw json.RawMessage) (string, bool) {
+	if !d.expect(path, raw, "string") {
+		return "", false
+	}
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		d.fail(path, "%v", err)
+		return "", false
+	}
+	return value, true
+}
+
+func (d *settingsDecoder) stringList(path string, raw json.RawMessage) ([]string, bool) {
+	values := []string{}
+	valid := true
+	ok := d.array(path, raw, func(path string, raw json.RawMessage) {
+		value, ok := d.string(path, raw)
+		valid = valid && ok
+		values = append(values, value)
+	})
+	return values, ok && valid
+}
+
+func (d *settingsDecoder) bool(path string, raw json.RawMessage) (bool, bool) {
+	if !d.expect(path, raw, "boolean") {
+		return false, false
+	}
+	var value bool
+	if err := json.Unmarshal(raw, &value); err != nil {
+		d.fail(path, "%v", err)
+		return false, false
+	}
+	return value, true
+}
+
+func (d *settingsDecoder) uint16(path string, raw json.RawMessage) (uint16, bool) {
+	if !d.expect(path, raw, "number") {
+		return 0, false
+	}
+	var value uint16
+	if err := json.Unmarshal(raw, &value); err != nil {
+		d.fail(path, "expected an integer between 0 and 65535, got %s", string(raw))
+		return 0, false
+	}
+	return value, true
+}
+
+func (d *settingsDecoder) positiveInt(path string, raw json.RawMessage) (int, bool) {
+	if !d.expect(path, raw, "number") {
+		return 0, false
+	}
+	var value int32
+	if err := json.Unmarshal(raw, &value); err != nil || value <= 0 {
+		d.fail(path, "expected a positive integer, got %s", string(raw))
+		return 0, false
+	}
+	return int(value), true
+}
+
+func (d *settingsDecoder) regularExpression(path string, raw json.RawMessage) (*RegularExpression, bool) {
+	expr, ok := d.string(path, raw)
+	if !ok {
+		return nil, false
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		var syntaxErr *syntax.Error
+		if errors.As(err, &syntaxErr) {
+			d.fail(path, "invalid regex: %s: `%s`", syntaxErr.Code, syntaxErr.Expr)
+		} else {
+			d.fail(path, "invalid regex: %v", err)
+		}
+		return nil, false
+	}
+	return &RegularExpression{re}, true
+}
+
+func (d *settingsDecoder) messageTemplate(path string, raw json.RawMessage) (*MessageTemplate, bool) {
+	text, ok := d.string(path, raw)
+	if !ok {
+		return nil, false
+	}
+
+	tmpl, err := ParseMessageTemplate(text)
+	if err != nil {
+		d.fail(path, "%v", err)
+		return nil, false
+	}
+	return tmpl, true
+}
+
+func (d *settingsDecoder) labelKey(path, key string) bool {
+	if problems := labelKeyErrors(key); len(problems) > 0 {
+		d.fail(path, "%q is not a valid label key: %s", key, strings.Join(problems, ", "))
+		return false
+	}
+	return true
+}
+
+// Validates the key of a rule. The keys of the denied rules can be glob
+// patterns, like `example.com/*`: the pattern must produce valid label
+// keys once its wildcards are replaced
+func (d *settingsDecoder) ruleKey(path, key string, ruleType RuleType) bool {
+	if ruleType != DeniedRule || !isKeyPattern(key) {
+		return d.labelKey(path, key)
+	}
+	expanded := strings.ReplaceAll(key, keyWildcard, "x")
+	if problems := labelKeyErrors(expanded); len(problems) > 0 {
+		d.fail(path, "%q is not a valid label key pattern: %s", key, strings.Join(problems, ", "))
+		return false
+	}
+	return true
+}
+
+// Returns the decoders of the fields shared by all the rules written
+// in long form
+func (d *settingsDecoder) ruleOptionsFields(options *RuleOptions) fieldDecoders {
+	return fieldDecoders{
+		"message": func(path string, raw json.RawMessage) {
+			options.Message, _ = d.messageTemplate(path, raw)
+		},
+		"id": func(path string, raw json.RawMessage) {
+			options.ID, _ = d.string(path, raw)
+		},
+		"severity": func(path string, raw json.RawMessage) {
+			options.Severity, _ = d.string(path, raw)
+		},
+		"docs_url": func(path string, raw json.RawMessage) {
+			options.DocsURL, _ = d.string(path, raw)
+		},
+		"controls": func(path string, raw json.RawMessage) {
+			options.Controls, _ = d.stringList(path, raw)
+		},
+		"code": func(path string, raw json.RawMessage) {
+			options.Code, _ = d.uint16(path, raw)
+		},
+	}
+}
+
+// Decodes a denied or mandatory label, written either as a plain
+// string or in long form
+func (d *settingsDecoder) labelRule(path string, raw json.RawMessage, ruleType RuleType) (labelRule, bool) {
+	rule := labelRule{}
+
+	switch jsonKind(raw) {
+	case "string":
+		key, ok := d.string(path, raw)
+		if !ok {
+			return rule, false
+		}
+		rule.Key = key
+		return rule, d.ruleKey(path, key, ruleType)
+	case "object":
+		errsBefore := len(d.errs)
+		fields := d.ruleOptionsFields(&rule.RuleOptions)
+		fields["key"] = func(path string, raw json.RawMessage) {
+			if key, ok := d.string(path, raw); ok && d.ruleKey(path, key, ruleType) {
+				rule.Key = key
+			}
+		}
+		if !d.object(path, raw, fields) {
+			return rule, false
+		}
+		if rule.Key == "" && len(d.errs) == errsBefore {
+			d.fail(path, "missing required field key")
+		}
+		return rule, len(d.errs) == errsBefore
+	default:
+		d.fail(path, "expected a string or an object, got %s", withArticle(jsonKind(raw)))
+		return rule, false
+	}
+}
+
+// Returns the decoders of the fields that define a label constraint
+func (d *settingsDecoder) constraintFields(pattern **RegularExpression, allowedValues *[]string) fieldDecoders {
+	return fieldDecoders{
+		"pattern": func(path string, raw json.RawMessage) {
+			*pattern, _ = d.regularExpression(path, raw)
+		},
+		"allowed_values": func(path string, raw json.RawMessage) {
+			if values, ok := d.stringList(path, raw); ok {
+				if len(values) == 0 {
+					d.fail(path, "must contain at least one value")
+					return
+				}
+				*allowedValues = values
+			}
+		},
+	}
+}
+
+// Ensures a constraint is defined either by a pattern or by a list of
+// allowed values. In the latter case, the pattern is generated
+func (d *settingsDecoder) completeConstraint(path string, pattern **RegularExpression, allowedValues []string) bool {
+	switch {
+	case *pattern != nil && allowedValues != nil:
+		d.fail(path, "cannot have both pattern and allowed_values")
+		return false
+	case allowedValues != nil:
+		*pattern = allowedValuesRegularExpression(allowedValues)
+	case *pattern == nil:
+		d.fail(path, "needs either a pattern or allowed_values")
+		return false
+	}
+	return true
+}
+
+// Decodes a label constraint, written either as a plain string holding
+// a regular expression or in long form
+func (d *settingsDecoder) labelConstraint(path string, raw json.RawMessage) (labelConstraint, bool) {
+	constraint := labelConstraint{}
+
+	switch jsonKind(raw) {
+	case "string":
+		re, ok := d.regularExpression(path, raw)
+		constraint.Pattern = re
+		return constraint, ok
+	case "object":
+		errsBefore := len(d.errs)
+		fields := d.ruleOptionsFields(&constraint.RuleOptions)
+		for name, decode := range d.constraintFields(&constraint.Pattern, &constraint.AllowedValues) {
+			fields[name] = decode
+		}
+		if !d.object(path, raw, fields) || len(d.errs) != errsBefore {
+			return constraint, false
+		}
+		return constraint, d.completeConstraint(path, &constraint.Pattern, constraint.AllowedValues)
+	default:
+		d.fail(path, "expected a string or an object, got %s", withArticle(jsonKind(raw)))
+		return constraint, false
+	}
+}
+
+func (d *settingsDecoder) ruleType(path string, raw json.RawMessage) (RuleType, bool) {
+	ruleType, ok := d.string(path, raw)
+	if !ok {
+		return "", false
+	}
+	if _, known := ruleTypeCategories[RuleType(ruleType)]; !known {
+		d.fail(path, "unknown rule type %q, must be one of: %s, %s, %s",
+			ruleType, DeniedRule, MandatoryRule, ConstrainedRule)
+		return "", false
+	}
+	return RuleType(ruleType), true
+}
+
+// Decodes a rule of the v2 schema
+func (d *settingsDecoder) rule(path string, raw json.RawMessage) (Rule, bool) {
+	rule := Rule{}
+	errsBefore := len(d.errs)
+
+	fields := d.ruleOptionsFields(&rule.RuleOptions)
+	for name, decode := range d.constraintFields(&rule.Pattern, &rule.AllowedValues) {
+		fields[name] = decode
+	}
+	fields["config_map"] = func(path string, raw json.RawMessage) {
+		rule.ConfigMap, _ = d.configMapSource(path, raw)
+	}
+	fields["references"] = func(path string, raw json.RawMessage) {
+		rule.References, _ = d.objectReference(path, raw)
+	}
+	fields["unique"] = func(path string, raw json.RawMessage) {
+		rule.Unique, _ = d.uniqueConstraint(path, raw)
+	}
+	fields["type"] = func(path string, raw json.RawMessage) {
+		rule.Type, _ = d.ruleType(path, raw)
+	}
+	keyPath := ""
+	fields["key"] = func(path string, raw json.RawMessage) {
+		rule.Key, _ = d.string(path, raw)
+		keyPath = path
+	}
+
+	if !d.object(path, raw, fields) || len(d.errs) != errsBefore {
+		return rule, false
+	}
+	if rule.Key != "" && !d.ruleKey(keyPath, rule.Key, rule.Type) {
+		return rule, false
+	}
+
+	// the constraints that are not defined by a pattern
+	sources := []string{}
+	if rule.ConfigMap != nil {
+		sources = append(sources, "config_map")
+	}
+	if rule.References != nil {
+		sources = append(sources, "references")
+	}
+	if rule.Unique != nil {
+		sources = append(sources, "unique")
+	}
+
+	switch {
+	case rule.Type == "":
+		d.fail(path, "missing required field type")
+	case rule.Key == "":
+		d.fail(path, "missing required field key")
+	case rule.Type != ConstrainedRule:
+		if rule.Pattern != nil || rule.AllowedValues != nil || len(sources) > 0 {
+			d.fail(path, "pattern, allowed_values, config_map, references and unique can be set only on rules of type %s",
+				ConstrainedRule)
+		}
+	case len(sources) > 1:
+		d.fail(path, "cannot have both %s and %s", sources[0], sources[1])
+	case len(sources) == 1:
+		if rule.Pattern != nil || rule.AllowedValues != nil {
+			d.fail(path, "cannot have %s together with pattern or allowed_values", sources[0])
+		}
+	default:
+		d.completeConstraint(path, &rule.Pattern, rule.AllowedValues)
+	}
+
+	return rule, len(d.errs) == errsBefore
+}
+
+// Decodes a list of rules of the v2 schema, rejecting the ones that have
+// the same type and key
+func (d *settingsDecoder) rules(path string, raw json.RawMessage) []Rule {
+	rules := []Rule{}
+	seen := make(map[RuleRef]struct{})
+	d.array(path, raw, func(path string, raw json.RawMessage) {
+		rule, ok := d.rule(path, raw)
+		if !ok {
+			return
+		}
+		if _, duplicated := seen[rule.ref()]; duplicated {
+			d.fail(path, "duplicated %s rule for label %s", rule.Type, rule.Key)
+			return
+		}
+		seen[rule.ref()] = struct{}{}
+		rules = append(rules, rule)
+	})
+	return rules
+}
+
+// Decodes a list of built-in presets
+func (d *settingsDecoder) presets(path string, raw json.RawMessage) []string {
+	names := []string{}
+	d.array(path, raw, func(path string, raw json.RawMessage) {
+		if name, ok := d.preset(path, raw); ok {
+			names = append(names, name)
+		}
+	})
+	return names
+}
+
+// Decodes the name of a built-in preset
+func (d *settingsDecoder) preset(path string, raw json.RawMessage) (string, bool) {
+	name, ok := d.string(path, raw)
+	if !ok {
+		return "", false
+	}
+	if _, known := presets[name]; known {
+		return name, true
+	}
+
+	names := presetNames()
+	if suggestion, found := closestNearMiss(name, names); found {
+		d.fail(path, "unknown preset %q, did you mean %q?", name, suggestion)
+	} else {
+		d.fail(path, "unknown preset %q, known presets are: %s", name, strings.Join(names, ", "))
+	}
+	return "", false
+}
+
+// Decodes the whole settings document. Documents using the v1 schema
+// are converted to the v2 one
+func (d *settingsDecoder) settings(raw json.RawMessage) Settings {
+	s := Settings{
+		DeniedLabels:    mapset.NewThreadUnsafeSet[string](),
+		MandatoryLabels: mapset.NewThreadUnsafeSet[string](),
+	}
+
+	version := SettingsVersion1
+	versionPath := ""
+	rules := []Rule{}
+	// the fields that require version 2
+	v2Paths := []string{}
+	v1 := settingsV1Rules{}
+	v1Paths := []string{}
+	selectedPresets := []string{}
+	profilesPath := "/profiles"
+	selectorPaths := []string{}
+
+	if jsonKind(raw) == "null" {
+		// settings that are not set at all, like null fields
+		raw = json.RawMessage("{}")
+	}
+
+	labelRules := func(rules *[]labelRule, ruleType RuleType) fieldDecoder {
+		return func(path string, raw json.RawMessage) {
+			v1.Fields = append(v1.Fields, path[1:])
+			v1Paths = append(v1Paths, path)
+			d.array(path, raw, func(path string, raw json.RawMessage) {
+				if rule, ok := d.labelRule(path, raw, ruleType); ok {
+					*rules = append(*rules, rule)
+				}
+			})
+		}
+	}
+
+	var definitions json.RawMessage
+	if jsonKind(raw) == "object" {
+		for _, entry := range objectEntries(raw) {
+			switch {
+			case entry.key == "definitions" && definitions == nil:
+				definitions = entry.value
+			case entry.key == "limits" && jsonKind(entry.value) == "object":
+				// the limits are needed to expand the references, their
+				// problems are reported when decoding them
+				d.expansionLimits = (&settingsDecoder{}).limits(entry.key, entry.value)
+			}
+		}
+	}
+	d.loadDefinitions("/definitions", definitions)
+
+	d.object("", raw, fieldDecoders{
+		"definitions": func(path string, raw json.RawMessage) {
+			// already loaded
+		},
+		"version": func(path string, raw json.RawMessage) {
+			if v, ok := d.uint16(path, raw); ok {
+				version = int(v)
+				versionPath = path
+			}
+		},
+		"rules": func(path string, raw json.RawMessage) {
+			v2Paths = append(v2Paths, path)
+			rules = d.rules(path, raw)
+		},
+		"presets": func(path string, raw json.RawMessage) {
+			selectedPresets = d.presets(path, raw)
+		},
+		"profiles": func(path string, raw json.RawMessage) {
+			v2Paths = append(v2Paths, path)
+			profilesPath = path
+			s.Profiles = make(map[string]Profile)
+			d.entries(path, raw, func(path, name string, raw json.RawMessage) {
+				if profile, ok := d.profile(path, raw); ok {
+					s.Profiles[name] = profile
+				}
+			})
+		},
+		"profile_selectors": func(path string, raw json.RawMessage) {
+			v2Paths = append(v2Paths, path)
+			d.array(path, raw, func(path string, raw json.RawMessage) {
+				if selector, ok := d.profileSelector(path, raw); ok {
+					s.ProfileSelectors = append(s.ProfileSelectors, selector)
+					selectorPaths = append(selectorPaths, path)
+				}
+			})
+		},
+		"limits": func(path string, raw json.RawMessage) {
+			s.Limits = d.limits(path, raw)
+		},
+		"namespace": func(path string, raw json.RawMessage) {
+			s.Namespace = d.namespaceRules(path, raw)
+		},
+		"owners": func(path string, raw json.RawMessage) {
+			s.Owners = d.ownerRules(path, raw)
+		},
+		"label_owners": func(path string, raw json.RawMessage) {
+			d.array(path, raw, func(path string, raw json.RawMessage) {
+				if owner, ok := d.labelOwner(path, raw); ok {
+					s.LabelOwners = append(s.LabelOwners, owner)
+				}
+			})
+		},
+		"conditions": func(path string, raw json.RawMessage) {
+			d.array(path, raw, func(path string, raw json.RawMessage) {
+				if condition, ok := d.labelCondition(path, raw); ok {
+					s.Conditions = append(s.Conditions, condition)
+				}
+			})
+		},
+		"allowed_labels": func(path string, raw json.RawMessage) {
+			if allowed, ok := d.allowedLabels(path, raw); ok {
+				s.AllowedLabels = allowed
+			}
+		},
+		"sticky_labels": func(path string, raw json.RawMessage) {
+			d.array(path, raw, func(path string, raw json.RawMessage) {
+				if key, ok := d.string(path, raw); ok && d.ruleKey(path, key, DeniedRule) {
+					s.StickyLabels = append(s.StickyLabels, key)
+				}
+			})
+		},
+		"tenant_policies": func(path string, raw json.RawMessage) {
+			if kind, ok := d.objectKind(path, raw); ok {
+				s.TenantPolicies = &kind
+			}
+		},
+		"context_aware": func(path string, raw json.RawMessage) {
+			s.ContextAware, _ = d.bool(path, raw)
+		},
+		string(DeniedLabelsRule):    labelRules(&v1.DeniedLabels, DeniedRule),
+		string(MandatoryLabelsRule): labelRules(&v1.MandatoryLabels, MandatoryRule),
+		string(ConstrainedLabelsRule): func(path string, raw json.RawMessage) {
+			v1.Fields = append(v1.Fields, path[1:])
+			v1Paths = append(v1Paths, path)
+			v1.ConstrainedLabels = make(map[string]labelConstraint)
+			d.entries(path, raw, func(path, label string, raw json.RawMessage) {
+				keyValid := d.labelKey(path, label)
+				constraint, ok := d.labelConstraint(path, raw)
+				if keyValid && ok {
+					v1.ConstrainedLabels[label] = constraint
+				}
+			})
+		},
+		"codes": func(path string, raw json.RawMessage) {
+			s.Codes = make(map[RuleCategory]uint16)
+			d.entries(path, raw, func(path, category string, raw json.RawMessage) {
+				if code, ok := d.uint16(path, raw); ok {
+					s.Codes[RuleCategory(category)] = code
+				}
+			})
+		},
+		"reject_near_miss_labels": func(path string, raw json.RawMessage) {
+			s.RejectNearMissLabels, _ = d.bool(path, raw)
+		},
+		"check_label_syntax": func(path string, raw json.RawMessage) {
+			s.CheckLabelSyntax, _ = d.bool(path, raw)
+		},
+		"analysis": func(path string, raw json.RawMessage) {
+			s.Analysis, _ = d.string(path, raw)
+		},
+	})
+
+	switch version {
+	case SettingsVersion1:
+		for _, path := range v2Paths {
+			d.fail(path, "requires version %d", SettingsVersion2)
+		}
+		rules = convertV1Rules(v1)
+		s.Deprecations = v1.deprecations()
+	case SettingsVersion2:
+		for _, path := range v1Paths {
+			d.fail(path, "not supported by version %d, use rules instead", SettingsVersion2)
+		}
+	default:
+		d.fail(versionPath, "unsupported version %d, must be either %d or %d",
+			version, SettingsVersion1, SettingsVersion2)
+	}
+
+	s.Version = version
+	s.addRules(expandPresets(selectedPresets, rules))
+	s.compileKeyMatchers()
+
+	d.resolveProfiles(&s, profilesPath)
+	for i, selector := range s.ProfileSelectors {
+		if _, found := s.Profiles[selector.Profile]; !found {
+			d.fail(jsonPointer(selectorPaths[i], "profile"), "unknown profile %q", selector.Profile)
+		}
+	}
+
+	return s
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The field of the objects that reference a definition:
+//
+//	{ "$ref": "team-name" }
+const refField = "$ref"
+
+// Reads the `definitions` section of the settings. Definitions are named
+// JSON values that can be referenced from any other place of the settings:
+// patterns, lists of allowed values or whole rules. Definitions can
+// reference each other, as long as they do not form a cycle
+func (d *settingsDecoder) loadDefinitions(path string, raw json.RawMessage) {
+	definitions := make(map[string]json.RawMessage)
+	d.brokenDefinitions = make(map[string]struct{})
+
+	if raw != nil && jsonKind(raw) != "null" {
+		d.entries(path, raw, func(path, name string, raw json.RawMessage) {
+			if name == "" {
+				d.fail(path, "definition name cannot be empty")
+				return
+			}
+			definitions[name] = raw
+		})
+	}
+
+	names := make([]string, 0, len(definitions))
+	for name := range definitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	refs := make(map[string][]string, len(definitions))
+	for _, name := range names {
+		errsBefore := len(d.errs)
+		refs[name] = d.collectRefs(jsonPointer(path, name), definitions[name], definitions)
+		if len(d.errs) != errsBefore {
+			d.brokenDefinitions[name] = struct{}{}
+		}
+	}
+
+	d.detectDefinitionCycles(path, names, refs)
+	d.definitions = definitions
+}
+
+// Returns the names of the definitions referenced by the given value,
+// reporting malformed and undefined references
+func (d *settingsDecoder) collectRefs(
+	path string,
+	raw json.RawMessage,
+	definitions map[string]json.RawMessage,
+) []string {
+	refs := []string{}
+
+	switch jsonKind(raw) {
+	case "object":
+		for _, entry := range objectEntries(raw) {
+			entryPath := jsonPointer(path, entry.key)
+			if entry.key != refField {
+				refs = append(refs, d.collectRefs(entryPath, entry.value, definitions)...)
+				continue
+			}
+			name, ok := d.string(entryPath, entry.value)
+			if !ok {
+				continue
+			}
+			if _, defined := definitions[name]; !defined {
+				d.fail(entryPath, "undefined definition %q", name)
+				continue
+			}
+			refs = append(refs, name)
+		}
+	case "array":
+		items := []json.RawMessage{}
+		if err := json.Unmarshal(raw, &items); err != nil {
+			d.fail(path, "%v", err)
+			return refs
+		}
+		for i, item := range items {
+			refs = append(refs, d.collectRefs(jsonPointer(path, fmt.Sprint(i)), item, definitions)...)
+		}
+	}
+
+	return refs
+}
+
+// Reports the definitions that reference themselves, either directly
+// or through other definitions
+func (d *settingsDecoder) detectDefinitionCycles(path string, names []string, refs map[string][]string) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(names))
+	stack := []string{}
+
+	var visit func(name string)
+	visit = func(name string) {
+		state[name] = visiting
+		stack = append(stack, name)
+
+		for _, ref := range refs[name] {
+			switch state[ref] {
+			case unvisited:
+				visit(ref)
+			case visiting:
+				start := 0
+				for i, n := range stack {
+					if n == ref {
+						start = i
+						break
+					}
+				}
+				cycle := append(append([]string{}, stack[start:]...), ref)
+				d.fail(jsonPointer(path, ref), "circular reference: %s", strings.Join(cycle, " -> "))
+				for _, n := range cycle {
+					d.brokenDefinitions[n] = struct{}{}
+				}
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		state[name] = visited
+	}
+
+	for _, name := range names {
+		if state[name] == unvisited {
+			visit(name)
+		}
+	}
+}
+
+// Returns true when the value is an object referencing a definition
+func isRef(raw json.RawMessage) bool {
+	return refValue(raw) != nil
+}
+
+// Returns the value of the `$ref` field of an object, if any
+func refValue(raw json.RawMessage) json.RawMessage {
+	if jsonKind(raw) != "object" {
+		return nil
+	}
+	for _, entry := range objectEntries(raw) {
+		if entry.key == refField {
+			return entry.value
+		}
+	}
+	return nil
+}
+
+// Replaces a reference with the value of the definition it points to.
+// An object holding both a reference and other fields is merged with the
+// definition, which must be an object as well: the fields of the object
+// take precedence over the ones of the definition
+func (d *settingsDecoder) resolve(path string, raw json.RawMessage) (json.RawMessage, bool) {
+	if d.definitions == nil {
+		// the definitions are being loaded
+		return raw, true
+	}
+
+	ref := refValue(raw)
+	if ref == nil {
+		return raw, true
+	}
+
+	refPath := jsonPointer(path, refField)
+	name, ok := d.string(refPath, ref)
+	if !ok {
+		return nil, false
+	}
+	if _, broken := d.brokenDefinitions[name]; broken {
+		d.fail(refPath, "definition %q is not valid", name)
+		return nil, false
+	}
+	definition, defined := d.definitions[name]
+	if !defined {
+		d.fail(refPath, "undefined definition %q", name)
+		return nil, false
+	}
+
+	definition, ok = d.resolvedDefinition(path, name, definition)
+	if !ok || !d.expand(refPath, 0, len(definition)) {
+		return nil, false
+	}
+
+	local := objectEntries(raw)
+	if len(local) == 1 {
+		return definition, true
+	}
+
+	if jsonKind(definition) != "object" {
+		d.fail(refPath, "definition %q is %s, it cannot be merged with other fields",
+			name, withArticle(jsonKind(definition)))
+		return nil, false
+	}
+
+	overridden := make(map[string]struct{}, len(local))
+	for _, entry := range local {
+		overridden[entry.key] = struct{}{}
+	}
+	merged := []objectEntry{}
+	for _, entry := range objectEntries(definition) {
+		if _, found := overridden[entry.key]; !found {
+			merged = append(merged, entry)
+		}
+	}
+	for _, entry := range local {
+		if entry.key != refField {
+			merged = append(merged, entry)
+		}
+	}
+
+	return encodeObjectEntries(merged), true
+}
+
+// Returns the value of the definition once its own reference, if any,
+// has been resolved. Each definition is resolved only once
+func (d *settingsDecoder) resolvedDefinition(path, name string, definition json.RawMessage) (json.RawMessage, bool) {
+	if resolved, found := d.resolvedDefinitions[name]; found {
+		return resolved, true
+	}
+	resolved, ok := d.resolve(path, definition)
+	if !ok {
+		return nil, false
+	}
+	if d.resolvedDefinitions == nil {
+		d.resolvedDefinitions = make(map[string]json.RawMessage)
+	}
+	d.resolvedDefinitions[name] = resolved
+	return resolved, true
+}
+
+// Accounts for the items and the bytes produced by expanding a reference.
+// Definitions referencing each other many times can make a small document
+// expand to a huge one: the expansion fails once it produces more items
+// than the allowed rules, or more bytes than the allowed settings size
+func (d *settingsDecoder) expand(path string, items, size int) bool {
+	if d.expansionExceeded {
+		return false
+	}
+	d.expandedItems += items
+	d.expandedSize += size
+
+	switch limits := d.expansionLimits; {
+	case d.expandedItems > limits.maxRules():
+		d.fail(path, "the references expand beyond the limit of %d items", limits.maxRules())
+	case d.expandedSize > limits.maxSettingsSize():
+		d.fail(path, "the references expand beyond the limit of %d bytes", limits.maxSettingsSize())
+	default:
+		return true
+	}
+	d.expansionExceeded = true
+	return false
+}
+
+// A field of a JSON object
+type objectEntry struct {
+	key   string
+	value json.RawMessage
+}
+
+// Returns the fields of a JSON object, following the order of the
+// document. The object is assumed to be well formed
+func objectEntries(raw json.RawMessage) []objectEntry {
+	entries := []objectEntry{}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if _, err := dec.Token(); err != nil {
+		return entries
+	}
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return entries
+		}
+		key, _ := token.(string)
+
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return entries
+		}
+		entries = append(entries, objectEntry{key: key, value: value})
+	}
+
+	return entries
+}
+
+func encodeObjectEntries(entries []objectEntry) json.RawMessage {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, entry := range entries {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(entry.key)
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(entry.value)
+	}
+	b.WriteByte('}')
+	return b.Bytes()
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+)
+
+func TestResolveDefinitions(t *testing.T) {
+	settings, err := NewSettingsFromValidateSettingsPayload([]byte(`
+	{
+		"version": 2,
+		"definitions": {
+			"team-name": "^team-[a-z]+$",
+			"environments": [ "prod", "staging" ],
+			"team-validator": { "type": "constrained", "pattern": { "$ref": "team-name" }, "severity": "high" }
+		},
+		"rules": [
+			{ "$ref": "team-validator", "key": "owner" },
+			{ "$ref": "team-validator", "key": "approver", "severity": "low" },
+			{ "type": "constrained", "key": "env", "allowed_values": [ { "$ref": "environments" }, "dev" ] }
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	actual, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	expected := `{"version":2,"rules":[` +
+		`{"type":"constrained","key":"approver","pattern":"^team-[a-z]+$","severity":"low"},` +
+		`{"type":"constrained","key":"env","allowed_values":["prod","staging","dev"]},` +
+		`{"type":"constrained","key":"owner","pattern":"^team-[a-z]+$","severity":"high"}]}`
+	if string(actual) != expected {
+		t.Errorf("Unexpected settings: %s", actual)
+	}
+}
+
+func TestResolveDefinitionsInsideOfV1Settings(t *testing.T) {
+	settings, err := NewSettingsFromValidateSettingsPayload([]byte(`
+	{
+		"definitions": { "team-name": "^team-" },
+		"constrained_labels": {
+			"owner": { "$ref": "team-name" },
+			"approver": { "$ref": "team-name" }
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	for _, label := range []string{"owner", "approver"} {
+		re, found := settings.ConstrainedLabels[label]
+		if !found || re.String() != "^team-" {
+			t.Errorf("Unexpected constraint of %s: %v", label, re)
+		}
+	}
+}
+
+func TestDetectNotValidDefinitions(t *testing.T) {
+	cases := []struct {
+		name            string
+		settings        string
+		expectedMessage string
+	}{
+		{
+			name: "undefined name",
+			settings: `{
+				"version": 2,
+				"rules": [{ "type": "constrained", "key": "owner", "pattern": { "$ref": "team-name" } }]
+			}`,
+			expectedMessage: `/rules/0/pattern/$ref: undefined definition "team-name"`,
+		},
+		{
+			name: "undefined name inside of a definition",
+			settings: `{
+				"version": 2,
+				"definitions": { "owner": { "pattern": { "$ref": "team-name" } } },
+				"rules": [{ "$ref": "owner", "type": "constrained", "key": "owner" }]
+			}`,
+			expectedMessage: `/definitions/owner/pattern/$ref: undefined definition "team-name"; ` +
+				`/rules/0/$ref: definition "owner" is not valid`,
+		},
+		{
+			name: "cycle",
+			settings: `{
+				"version": 2,
+				"definitions": {
+					"a": { "pattern": { "$ref": "b" } },
+					"b": [ { "$ref": "c" } ],
+					"c": { "$ref": "a" }
+				},
+				"rules": [{ "type": "constrained", "key": "owner", "allowed_values": { "$ref": "b" } }]
+			}`,
+			expectedMessage: "/definitions/a: circular reference: a -> b -> c -> a; " +
+				`/rules/0/allowed_values/$ref: definition "b" is not valid`,
+		},
+		{
+			name: "reference that is not a string",
+			settings: `{
+				"version": 2,
+				"rules": [{ "$ref": 42 }]
+			}`,
+			expectedMessage: "/rules/0/$ref: expected a string, got a number",
+		},
+		{
+			name: "merge with a definition that is not an object",
+			settings: `{
+				"version": 2,
+				"definitions": { "team-name": "^team-" },
+				"rules": [{ "$ref": "team-name", "type": "denied", "key": "owner" }]
+			}`,
+			expectedMessage: `/rules/0/$ref: definition "team-name" is a string, it cannot be merged with other fields`,
+		},
+		{
+			name: "definition of the wrong type",
+			settings: `{
+				"version": 2,
+				"definitions": { "environments": [ "prod" ] },
+				"rules": [{ "type": "constrained", "key": "env", "pattern": { "$ref": "environments" } }]
+			}`,
+			expectedMessage: "/rules/0/pattern: expected a string, got an array",
+		},
+	}
+
+	// each definition lists the previous one 10 times: the last one
+	// expands to 10^depth labels
+	nestedDefinitions := func(depth int) string {
+		definitions := []string{`"a0": [ "x" ]`}
+		for i := 1; i <= depth; i++ {
+			refs := strings.TrimSuffix(strings.Repeat(fmt.Sprintf(`{ "$ref": "a%d" },`, i-1), 10), ",")
+			definitions = append(definitions, fmt.Sprintf(`"a%d": [ %s ]`, i, refs))
+		}
+		return strings.Join(definitions, ", ")
+	}
+	cases = append(cases, []struct {
+		name            string
+		settings        string
+		expectedMessage string
+	}{
+		{
+			name: "references expanding to too many items",
+			settings: `{
+				"definitions": { ` + nestedDefinitions(7) + ` },
+				"denied_labels": [ { "$ref": "a7" } ]
+			}`,
+			expectedMessage: "/denied_labels/0: the references expand beyond the limit of 10000 items",
+		},
+		{
+			name: "references expanding beyond the lowered limits",
+			settings: `{
+				"version": 2,
+				"limits": { "max_settings_size": 2048 },
+				"definitions": { ` + nestedDefinitions(3) + ` },
+				"rules": [ { "type": "constrained", "key": "env", "allowed_values": { "$ref": "a3" } } ]
+			}`,
+			expectedMessage: "/rules/0/allowed_values/0/$ref: the references expand beyond the limit of 2048 bytes",
+		},
+	}...)
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			responsePayload, err := validateSettings([]byte(tc.settings))
+			if err != nil {
+				t.Fatalf("Unexpected error %+v", err)
+			}
+
+			var response kubewarden_protocol.SettingsValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			if response.Valid {
+				t.Fatal("Expected settings to not be valid")
+			}
+			expected := "Provided settings are not valid: " + tc.expectedMessage
+			if *response.Message != expected {
+				t.Errorf("Unexpected validation error message: %s", *response.Message)
+			}
+		})
+	}
+}
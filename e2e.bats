@@ -111,5 +111,38 @@
 
   # settings validation fails
   [ "$status" -eq 1 ]
-  [ $(expr "$output" : ".*Provided settings are not valid: error parsing regexp: missing closing ]: `[12$`.*") -ne 0 ]
+  [ $(expr "$output" : ".*Provided settings are not valid: /constrained_labels/cc-center: invalid regex: missing closing ]: `[12$`.*") -ne 0 ]
+}
+
+@test "fail settings validation because of unknown field" {
+  run kwctl run annotated-policy.wasm \
+    -r test_data/ingress.json \
+    --settings-json '{"denied_label": ["foo"]}'
+
+  # this prints the output when one the checks below fails
+  echo "output = ${output}"
+
+  # settings validation fails
+  [ "$status" -eq 1 ]
+  [ $(expr "$output" : '.*Provided settings are not valid: /denied_label: unknown field.*') -ne 0 ]
+}
+
+@test "the policy can look up the Services referenced by labels" {
+  run kwctl inspect --output yaml annotated-policy.wasm
+
+  # this prints the output when one the checks below fails
+  echo "output = ${output}"
+
+  [ "$status" -eq 0 ]
+  [[ "$output" == *"kind: Service"* ]]
+}
+
+@test "the policy can look up the tenant policies" {
+  run kwctl inspect --output yaml annotated-policy.wasm
+
+  # this prints the output when one the checks below fails
+  echo "output = ${output}"
+
+  [ "$status" -eq 0 ]
+  [[ "$output" == *"kind: LabelPolicy"* ]]
 }
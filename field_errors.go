@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+)
+
+// A problem found inside of the settings, along with the JSON pointer
+// of the offending value
+type fieldError struct {
+	path    string
+	message string
+}
+
+func (e fieldError) Error() string {
+	return e.path + ": " + e.message
+}
+
+// All the problems found inside of the settings
+type fieldErrors []fieldError
+
+func (e fieldErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Builds a JSON pointer (RFC 6901) by appending the given tokens to
+// `base`. Tokens are escaped, hence label keys like
+// `app.kubernetes.io/name` can be used safely
+func jsonPointer(base string, tokens ...string) string {
+	var b strings.Builder
+	b.WriteString(base)
+	for _, token := range tokens {
+		token = strings.ReplaceAll(token, "~", "~0")
+		token = strings.ReplaceAll(token, "/", "~1")
+		b.WriteString("/")
+		b.WriteString(token)
+	}
+	return b.String()
+}
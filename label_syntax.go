@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// The rules enforced by Kubernetes on label keys and values, see
+// https://kubernetes.io/docs/concepts/overview/working-with-objects/labels/#syntax-and-character-set
+const (
+	labelNameMaxLength   = 63
+	labelPrefixMaxLength = 253
+)
+
+var (
+	labelNameRegexp   = regexp.MustCompile(`^([A-Za-z0-9][-A-Za-z0-9_.]*)?[A-Za-z0-9]$`)
+	labelPrefixRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+)
+
+// Checks a label key against the Kubernetes qualified name rules: an
+// optional DNS subdomain prefix, followed by a slash, and a name of at
+// most 63 characters. Returns the list of problems found
+func labelKeyErrors(key string) []string {
+	errors := []string{}
+
+	name := key
+	if prefix, suffix, found := strings.Cut(key, "/"); found {
+		name = suffix
+		switch {
+		case prefix == "":
+			errors = append(errors, "prefix part must be non-empty")
+		case len(prefix) > labelPrefixMaxLength:
+			errors = append(errors, fmt.Sprintf(
+				"prefix part must be no more than %d characters", labelPrefixMaxLength))
+		case !labelPrefixRegexp.MatchString(prefix):
+			errors = append(errors,
+				"prefix part must be a lowercase DNS subdomain, like 'example.com'")
+		}
+	}
+
+	switch {
+	case name == "":
+		errors = append(errors, "name part must be non-empty")
+	case len(name) > labelNameMaxLength:
+		errors = append(errors, fmt.Sprintf(
+			"name part must be no more than %d characters", labelNameMaxLength))
+	case !labelNameRegexp.MatchString(name):
+		errors = append(errors,
+			"name part must consist of alphanumeric characters, '-', '_' or '.', "+
+				"and must start and end with an alphanumeric character")
+	}
+
+	return errors
+}
+
+// Checks a label value against the Kubernetes rules. Returns the list
+// of problems found
+func labelValueErrors(value string) []string {
+	errors := []string{}
+
+	if len(value) > labelNameMaxLength {
+		errors = append(errors, fmt.Sprintf(
+			"must be no more than %d characters", labelNameMaxLength))
+	}
+	if value != "" && !labelNameRegexp.MatchString(value) {
+		errors = append(errors,
+			"must be empty or consist of alphanumeric characters, '-', '_' or '.', "+
+				"and must start and end with an alphanumeric character")
+	}
+
+	return errors
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLabelKeyErrors(t *testing.T) {
+	cases := []struct {
+		key   string
+		valid bool
+	}{
+		{"owner", true},
+		{"app.kubernetes.io/name", true},
+		{"example.com/Cost_Center.1", true},
+		{"app.kubernetes.io//name", false},
+		{"/name", false},
+		{"Example.com/name", false},
+		{"example.com/", false},
+		{"-owner", false},
+		{"owner name", false},
+		{strings.Repeat("a", 64), false},
+		{strings.Repeat("a", 63), true},
+	}
+
+	for _, tc := range cases {
+		errors := labelKeyErrors(tc.key)
+		if tc.valid && len(errors) > 0 {
+			t.Errorf("Expected %q to be valid, got: %v", tc.key, errors)
+		}
+		if !tc.valid && len(errors) == 0 {
+			t.Errorf("Expected %q to not be valid", tc.key)
+		}
+	}
+}
+
+func TestLabelValueErrors(t *testing.T) {
+	cases := []struct {
+		value string
+		valid bool
+	}{
+		{"", true},
+		{"team-infra", true},
+		{"v1.2_3", true},
+		{"team infra", false},
+		{"team-", false},
+		{strings.Repeat("a", 64), false},
+	}
+
+	for _, tc := range cases {
+		errors := labelValueErrors(tc.value)
+		if tc.valid && len(errors) > 0 {
+			t.Errorf("Expected %q to be valid, got: %v", tc.value, errors)
+		}
+		if !tc.valid && len(errors) == 0 {
+			t.Errorf("Expected %q to not be valid", tc.value)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp/syntax"
+	"sort"
+)
+
+// Default values of the limits enforced on the settings. These are hard
+// limits too: the settings can lower them, but not raise them
+const (
+	DefaultMaxRules                  = 10000
+	DefaultMaxPatternLength          = 1024
+	DefaultMaxProgramSize            = 10000
+	DefaultMaxSettingsSize           = 1024 * 1024
+	DefaultMaxKeyPatternsProgramSize = 50000
+)
+
+// Limits protects the cluster from settings that would slow down every
+// admission request, like huge regular expressions or tens of thousands of
+// rules. Zero values are replaced by the defaults, which are also the
+// highest values allowed
+type Limits struct {
+	// The maximum number of rules of the settings and of each profile
+	MaxRules int `json:"max_rules,omitempty"`
+	// The maximum length of the patterns of the constrained rules
+	MaxPatternLength int `json:"max_pattern_length,omitempty"`
+	// The maximum number of instructions of the program each regular
+	// expression is compiled to
+	MaxProgramSize int `json:"max_program_size,omitempty"`
+	// The maximum size of the settings document, in bytes
+	MaxSettingsSize int `json:"max_settings_size,omitempty"`
+	// The maximum number of instructions of the programs matching the
+	// key patterns of the denied and allowed labels. Matching a label key
+	// takes a time proportional to the length of the key times the size
+	// of the program
+	MaxKeyPatternsProgramSize int `json:"max_key_patterns_program_size,omitempty"`
+}
+
+func (l Limits) isZero() bool {
+	return l == Limits{}
+}
+
+func limitOrDefault(limit, defaultLimit int) int {
+	if limit > 0 {
+		return limit
+	}
+	return defaultLimit
+}
+
+// Returns the limit, or the hard limit when the limit is not set or
+// exceeds it
+func boundedLimit(limit, hardLimit int) int {
+	if limit > 0 && limit < hardLimit {
+		return limit
+	}
+	return hardLimit
+}
+
+func (l Limits) maxRules() int {
+	return boundedLimit(l.MaxRules, DefaultMaxRules)
+}
+
+func (l Limits) maxPatternLength() int {
+	return boundedLimit(l.MaxPatternLength, DefaultMaxPatternLength)
+}
+
+func (l Limits) maxProgramSize() int {
+	return boundedLimit(l.MaxProgramSize, DefaultMaxProgramSize)
+}
+
+func (l Limits) maxSettingsSize() int {
+	return boundedLimit(l.MaxSettingsSize, DefaultMaxSettingsSize)
+}
+
+func (l Limits) maxKeyPatternsProgramSize() int {
+	return boundedLimit(l.MaxKeyPatternsProgramSize, DefaultMaxKeyPatternsProgramSize)
+}
+
+// Returns the number of instructions of the program the regular
+// expression is compiled to
+func programSize(re *RegularExpression) int {
+	parsed, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil {
+		return 0
+	}
+	prog, err := syntax.Compile(parsed.Simplify())
+	if err != nil {
+		return 0
+	}
+	return len(prog.Inst)
+}
+
+// Checks the settings against their limits. The size of the document is
+// checked before decoding it, see checkSettingsSize
+func (s *Settings) validateLimits() []string {
+	errors := []string{}
+
+	// each condition counts as a rule
+	if rules := len(s.Rules()) + len(s.Conditions); rules > s.Limits.maxRules() {
+		errors = append(errors, fmt.Sprintf(
+			"there are %d rules, the limit is %d", rules, s.Limits.maxRules()))
+	}
+
+	labels := make([]string, 0, len(s.ConstrainedLabels))
+	for label := range s.ConstrainedLabels {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	for _, label := range labels {
+		_, generated := s.AllowedValues[label]
+		errors = append(errors, s.patternLimitErrors(
+			"constrained label "+label, s.ConstrainedLabels[label], generated)...)
+	}
+
+	for i, condition := range s.Conditions {
+		errors = append(errors, s.patternLimitErrors(
+			fmt.Sprintf("the when clause of condition %d", i),
+			condition.When.Pattern, condition.When.AllowedValues != nil)...)
+		if constraint := condition.Then.Constrained; constraint != nil {
+			errors = append(errors, s.patternLimitErrors(
+				fmt.Sprintf("constrained label %s of condition %d", constraint.Key, i),
+				constraint.Pattern, constraint.AllowedValues != nil)...)
+		}
+	}
+
+	return errors
+}
+
+// Checks the length and the program size of the pattern of `subject`.
+// Patterns generated from the allowed values are not written by the
+// user, only the size of their program matters
+func (s *Settings) patternLimitErrors(subject string, re *RegularExpression, generated bool) []string {
+	if re == nil || re.Regexp == nil {
+		return nil
+	}
+	if !generated {
+		if length := len(re.String()); length > s.Limits.maxPatternLength() {
+			return []string{fmt.Sprintf(
+				"the pattern of %s is %d characters long, the limit is %d",
+				subject, length, s.Limits.maxPatternLength())}
+		}
+	}
+	if size := programSize(re); size > s.Limits.maxProgramSize() {
+		return []string{fmt.Sprintf(
+			"the pattern of %s compiles to %d instructions, the limit is %d",
+			subject, size, s.Limits.maxProgramSize())}
+	}
+	return nil
+}
+
+// Decodes the limits, which cannot be raised above their defaults
+func (d *settingsDecoder) limits(path string, raw json.RawMessage) Limits {
+	limits := Limits{}
+
+	limit := func(value *int, hardLimit int) fieldDecoder {
+		return func(path string, raw json.RawMessage) {
+			if v, ok := d.positiveInt(path, raw); ok {
+				if v > hardLimit {
+					d.fail(path, "must be at most %d", hardLimit)
+					return
+				}
+				*value = v
+			}
+		}
+	}
+
+	d.object(path, raw, fieldDecoders{
+		"max_rules":                     limit(&limits.MaxRules, DefaultMaxRules),
+		"max_pattern_length":            limit(&limits.MaxPatternLength, DefaultMaxPatternLength),
+		"max_program_size":              limit(&limits.MaxProgramSize, DefaultMaxProgramSize),
+		"max_settings_size":             limit(&limits.MaxSettingsSize, DefaultMaxSettingsSize),
+		"max_key_patterns_program_size": limit(&limits.MaxKeyPatternsProgramSize, DefaultMaxKeyPatternsProgramSize),
+	})
+	return limits
+}
+
+// Rejects the settings documents that are too big, before spending time
+// decoding them. The document can lower the limit, but not raise it
+func checkSettingsSize(payload []byte, limits Limits) error {
+	if size := len(payload); size > limits.maxSettingsSize() {
+		return fmt.Errorf("the settings are %d bytes long, the limit is %d", size, limits.maxSettingsSize())
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+)
+
+func TestDetectNotValidSettingsDueToLimits(t *testing.T) {
+	cases := []struct {
+		name            string
+		settings        string
+		expectedMessage string
+	}{
+		{
+			name: "too many rules",
+			settings: `{
+				"version": 2,
+				"limits": { "max_rules": 2 },
+				"rules": [
+					{ "type": "denied", "key": "a" },
+					{ "type": "denied", "key": "b" },
+					{ "type": "denied", "key": "c" }
+				]
+			}`,
+			expectedMessage: "there are 3 rules, the limit is 2",
+		},
+		{
+			name: "pattern too long",
+			settings: `{
+				"version": 2,
+				"limits": { "max_pattern_length": 8 },
+				"rules": [ { "type": "constrained", "key": "owner", "pattern": "^team-[a-z]+$" } ]
+			}`,
+			expectedMessage: "the pattern of constrained label owner is 13 characters long, the limit is 8",
+		},
+		{
+			name: "program too big",
+			settings: `{
+				"version": 2,
+				"limits": { "max_program_size": 100 },
+				"rules": [ { "type": "constrained", "key": "owner", "pattern": "^a{1,50}b{1,50}$" } ]
+			}`,
+			expectedMessage: "the pattern of constrained label owner compiles to",
+		},
+		{
+			name: "allowed values compiling to a big program",
+			settings: `{
+				"version": 2,
+				"limits": { "max_program_size": 10 },
+				"rules": [ { "type": "constrained", "key": "env", "allowed_values": [ "production", "staging" ] } ]
+			}`,
+			expectedMessage: "the pattern of constrained label env compiles to",
+		},
+		{
+			name: "conditions counted as rules",
+			settings: `{
+				"version": 2,
+				"limits": { "max_rules": 2 },
+				"rules": [ { "type": "denied", "key": "a" } ],
+				"conditions": [
+					{ "when": { "key": "tier", "present": true }, "then": { "mandatory": [ "app" ] } },
+					{ "when": { "key": "tier", "absent": true }, "then": { "denied": [ "b" ] } }
+				]
+			}`,
+			expectedMessage: "there are 3 rules, the limit is 2",
+		},
+		{
+			name: "condition pattern too long",
+			settings: `{
+				"version": 2,
+				"limits": { "max_pattern_length": 8 },
+				"conditions": [
+					{ "when": { "key": "tier", "pattern": "^(web|api)-[a-z]+$" }, "then": { "mandatory": [ "app" ] } }
+				]
+			}`,
+			expectedMessage: "the pattern of the when clause of condition 0 is 18 characters long, the limit is 8",
+		},
+		{
+			name: "condition constraint compiling to a big program",
+			settings: `{
+				"version": 2,
+				"limits": { "max_program_size": 10 },
+				"conditions": [ {
+					"when": { "key": "tier", "present": true },
+					"then": { "constrained": { "key": "env", "allowed_values": [ "production", "staging" ] } }
+				} ]
+			}`,
+			expectedMessage: "the pattern of constrained label env of condition 0 compiles to",
+		},
+		{
+			name: "settings too big",
+			settings: `{
+				"version": 2,
+				"limits": { "max_settings_size": 64 },
+				"rules": [ { "type": "denied", "key": "` + strings.Repeat("a", 60) + `" } ]
+			}`,
+			expectedMessage: "the settings are",
+		},
+		{
+			name: "limits raised above the hard limits",
+			settings: `{
+				"version": 2,
+				"limits": { "max_rules": 20000, "max_program_size": 1000000 }
+			}`,
+			expectedMessage: "/limits/max_rules: must be at most 10000; /limits/max_program_size: must be at most 10000",
+		},
+		{
+			name: "settings too big, raising their own limit",
+			settings: `{
+				"version": 2,
+				"limits": { "max_settings_size": 1000000000 },
+				"rules": [ { "type": "denied", "key": "` + strings.Repeat("a", DefaultMaxSettingsSize) + `" } ]
+			}`,
+			expectedMessage: "the settings are 1048700 bytes long, the limit is 1048576",
+		},
+		{
+			name: "denied key patterns too complex",
+			settings: `{
+				"version": 2,
+				"limits": { "max_key_patterns_program_size": 10 },
+				"rules": [
+					{ "type": "denied", "key": "*-tmp" },
+					{ "type": "denied", "key": "*-debug" }
+				]
+			}`,
+			expectedMessage: "the denied key patterns are too complex: they compile to",
+		},
+		{
+			name: "malformed limits",
+			settings: `{
+				"version": 2,
+				"limits": { "max_rules": -1 }
+			}`,
+			expectedMessage: "/limits/max_rules: expected a positive integer, got -1",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			responsePayload, err := validateSettings([]byte(tc.settings))
+			if err != nil {
+				t.Fatalf("Unexpected error %+v", err)
+			}
+
+			var response kubewarden_protocol.SettingsValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			if response.Valid {
+				t.Fatal("Expected settings to not be valid")
+			}
+			expected := "Provided settings are not valid: " + tc.expectedMessage
+			if !strings.HasPrefix(*response.Message, expected) {
+				t.Errorf("Unexpected validation error message: %s", *response.Message)
+			}
+		})
+	}
+}
+
+func TestSettingsWithinLoweredLimits(t *testing.T) {
+	rules := []string{}
+	for i := 0; i < 20; i++ {
+		rules = append(rules, fmt.Sprintf(`{ "type": "denied", "key": "label-%d" }`, i))
+	}
+	settings := `{
+		"version": 2,
+		"limits": { "max_rules": 20, "max_settings_size": 4096 },
+		"rules": [` + strings.Join(rules, ",") + `]
+	}`
+
+	responsePayload, err := validateSettings([]byte(settings))
+	if err != nil {
+		t.Fatalf("Unexpected error %+v", err)
+	}
+
+	var response kubewarden_protocol.SettingsValidationResponse
+	if err := json.Unmarshal(responsePayload, &response); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	if !response.Valid {
+		t.Errorf("Expected settings to be valid: %s", *response.Message)
+	}
+}
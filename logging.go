@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+
+	kubewarden "github.com/kubewarden/policy-sdk-go"
+)
+
+// Sends the log events to the policy host
+var logWriter = kubewarden.KubewardenLogWriter{}
+
+// Log levels understood by the policy host
+const (
+	LogLevelDebug = "debug"
+	LogLevelInfo  = "info"
+	LogLevelWarn  = "warning"
+)
+
+// Emits a structured log event made of the level, the message and
+// the given fields
+func logEvent(level, message string, fields map[string]interface{}) {
+	event := make(map[string]interface{}, len(fields)+2)
+	for key, value := range fields {
+		event[key] = value
+	}
+	event["level"] = level
+	event["message"] = message
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	_, _ = logWriter.Write(append(line, '\n'))
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+
+	mapset "github.com/deckarep/golang-set/v2"
+)
+
+// The wildcard that can be used inside of the keys of the denied rules,
+// it matches any sequence of characters
+const keyWildcard = "*"
+
+// Returns true when the key of a rule is a pattern instead of a label key
+func isKeyPattern(key string) bool {
+	return strings.Contains(key, keyWildcard)
+}
+
+// Returns true when the pattern matches all the keys starting with
+// a given prefix, like `example.com/*`
+func isPrefixPattern(pattern string) bool {
+	return strings.Index(pattern, keyWildcard) == len(pattern)-1
+}
+
+// Translates a glob pattern into a regular expression
+func globExpression(pattern string) string {
+	parts := strings.Split(pattern, keyWildcard)
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return strings.Join(parts, ".*")
+}
+
+// Returns a regular expression matching the whole keys matched
+// by the glob pattern
+func globRegexp(pattern string) *regexp.Regexp {
+	return regexp.MustCompile("^" + globExpression(pattern) + "$")
+}
+
+// A set of glob patterns, merged into a single regular expression
+type globSet struct {
+	globs []string
+	// The regular expressions matching each one of the patterns
+	expressions []*regexp.Regexp
+	merged      *regexp.Regexp
+}
+
+func (g *globSet) add(glob string) {
+	g.globs = append(g.globs, glob)
+}
+
+// Compiles the patterns added so far, returns the number of
+// instructions of the resulting program
+func (g *globSet) compile() int {
+	if len(g.globs) == 0 {
+		return 0
+	}
+	expressions := make([]string, 0, len(g.globs))
+	for _, glob := range g.globs {
+		expressions = append(expressions, globExpression(glob))
+		g.expressions = append(g.expressions, globRegexp(glob))
+	}
+	merged := "^(?:" + strings.Join(expressions, "|") + ")$"
+	g.merged = regexp.MustCompile(merged)
+
+	parsed, err := syntax.Parse(merged, syntax.Perl)
+	if err != nil {
+		return 0
+	}
+	prog, err := syntax.Compile(parsed.Simplify())
+	if err != nil {
+		return 0
+	}
+	return len(prog.Inst)
+}
+
+// Returns the first pattern of the set that matches the key
+func (g *globSet) match(key string) (string, bool) {
+	if g.merged == nil || !g.merged.MatchString(key) {
+		return "", false
+	}
+	// this happens only when the key is denied, the pattern is
+	// looked up to report the right rule
+	for i, re := range g.expressions {
+		if re.MatchString(key) {
+			return g.globs[i], true
+		}
+	}
+	return "", false
+}
+
+// A trie indexing the patterns by their literal prefix, the part that
+// comes before the first wildcard. It finds the patterns that can match
+// a key in a time proportional to the length of the key
+type prefixTrie struct {
+	children map[byte]*prefixTrie
+	// The prefix pattern that ends at this node, like `example.com/*`
+	pattern string
+	// The other patterns whose literal prefix ends at this node,
+	// like `example.com/*-tmp`
+	globs globSet
+}
+
+func (t *prefixTrie) node(prefix string) *prefixTrie {
+	node := t
+	for i := 0; i < len(prefix); i++ {
+		if node.children == nil {
+			node.children = make(map[byte]*prefixTrie)
+		}
+		child, found := node.children[prefix[i]]
+		if !found {
+			child = &prefixTrie{}
+			node.children[prefix[i]] = child
+		}
+		node = child
+	}
+	return node
+}
+
+func (t *prefixTrie) insert(pattern string) {
+	literal := pattern[:strings.Index(pattern, keyWildcard)]
+	node := t.node(literal)
+	if isPrefixPattern(pattern) {
+		node.pattern = pattern
+	} else {
+		node.globs.add(pattern)
+	}
+}
+
+// Compiles the patterns of all the nodes, returns the total number of
+// instructions of their programs
+func (t *prefixTrie) compile() int {
+	size := t.globs.compile()
+	for _, child := range t.children {
+		size += child.compile()
+	}
+	return size
+}
+
+// Returns the first pattern matching the key, shorter prefixes first
+func (t *prefixTrie) match(key string) (string, bool) {
+	node := t
+	for i := 0; ; i++ {
+		if node.pattern != "" {
+			return node.pattern, true
+		}
+		if pattern, found := node.globs.match(key); found {
+			return pattern, true
+		}
+		if i == len(key) {
+			return "", false
+		}
+		child, found := node.children[key[i]]
+		if !found {
+			return "", false
+		}
+		node = child
+	}
+}
+
+// keyMatcher finds the rule matching a label key among thousands of them,
+// in a time that depends on the length of the key rather than on the
+// number of rules. Exact keys are kept inside of a set, while patterns are
+// indexed by their literal prefix inside of a trie. Only the patterns
+// starting with a wildcard are merged into a regular expression that is
+// evaluated against all the keys
+type keyMatcher struct {
+	exact    map[string]struct{}
+	patterns prefixTrie
+	// All the patterns, sorted, with their regular expressions
+	allPatterns []string
+	allRegexps  []*regexp.Regexp
+	// The total number of instructions of the programs matching the
+	// patterns, an upper bound of the cost of matching a key
+	programSize int
+}
+
+func newKeyMatcher(keys []string) *keyMatcher {
+	m := &keyMatcher{exact: make(map[string]struct{})}
+
+	sorted := append([]string{}, keys...)
+	sort.Strings(sorted)
+
+	for _, key := range sorted {
+		if !isKeyPattern(key) {
+			m.exact[key] = struct{}{}
+			continue
+		}
+		m.patterns.insert(key)
+		m.allPatterns = append(m.allPatterns, key)
+		m.allRegexps = append(m.allRegexps, globRegexp(key))
+	}
+	m.programSize = m.patterns.compile()
+
+	return m
+}
+
+// Returns the rule key matching the given label key: the label key
+// itself or a pattern
+func (m *keyMatcher) match(key string) (string, bool) {
+	if _, found := m.exact[key]; found {
+		return key, true
+	}
+	return m.patterns.match(key)
+}
+
+// Returns a pattern, other than `key` itself, that matches all the label
+// keys matched by `key`. Wildcards of `key` are treated like any other
+// character: a pattern matching them is going to match whatever they match
+func (m *keyMatcher) shadowingPattern(key string) (string, bool) {
+	for i, pattern := range m.allPatterns {
+		if pattern != key && m.allRegexps[i].MatchString(key) {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// Builds the structures used to match the label keys, this must be done
+// once all the rules have been added
+func (s *Settings) compileKeyMatchers() {
+	keys := []string{}
+	if s.DeniedLabels != nil {
+		keys = s.DeniedLabels.ToSlice()
+	}
+	s.deniedKeys = newKeyMatcher(keys)
+	if len(s.StickyLabels) > 0 {
+		s.stickyKeys = newKeyMatcher(s.StickyLabels)
+	}
+	s.compileAllowedKeys()
+}
+
+// Returns the key of the denied rule matching the given label, if any
+func (s *Settings) deniedRule(label string) (string, bool) {
+	if s.deniedKeys == nil {
+		// the settings have not been decoded, patterns are not supported
+		if s.DeniedLabels != nil && s.DeniedLabels.Contains(label) {
+			return label, true
+		}
+		return "", false
+	}
+	return s.deniedKeys.match(label)
+}
+
+// Returns the labels of the given set that are denied, either explicitly
+// or by a key pattern
+func (s *Settings) deniedAmong(labels mapset.Set[string]) mapset.Set[string] {
+	denied := mapset.NewThreadUnsafeSet[string]()
+	if labels == nil {
+		return denied
+	}
+	for label := range labels.Iter() {
+		if _, found := s.deniedRule(label); found {
+			denied.Add(label)
+		}
+	}
+	return denied
+}
+
+// Returns the rule violated by the given label
+func (s *Settings) violatedRule(category RuleCategory, label string) RuleRef {
+	if category == DeniedLabelsRule {
+		if key, found := s.deniedRule(label); found {
+			return RuleRef{category, key}
+		}
+	}
+	return RuleRef{category, label}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+	kubewarden_testing "github.com/kubewarden/policy-sdk-go/testing"
+)
+
+func TestKeyMatcher(t *testing.T) {
+	m := newKeyMatcher([]string{
+		"debug",
+		"example.com/*",
+		"example.com/team/*",
+		"*.internal/*",
+		"*-tmp",
+	})
+
+	cases := []struct {
+		key             string
+		expectedPattern string
+	}{
+		{"debug", "debug"},
+		{"debugging", ""},
+		{"example.com/owner", "example.com/*"},
+		{"example.com/team/name", "example.com/*"},
+		{"example.org/owner", ""},
+		{"billing.internal/cost", "*.internal/*"},
+		{"build-tmp", "*-tmp"},
+		{"tmp", ""},
+	}
+
+	for _, tc := range cases {
+		pattern, found := m.match(tc.key)
+		if found != (tc.expectedPattern != "") || pattern != tc.expectedPattern {
+			t.Errorf("%s: expected %q, got %q (%t)", tc.key, tc.expectedPattern, pattern, found)
+		}
+	}
+}
+
+func TestRejectionBecauseDeniedKeyPattern(t *testing.T) {
+	settings, err := NewSettingsFromValidateSettingsPayload([]byte(`
+	{
+		"version": 2,
+		"rules": [
+			{ "type": "denied", "key": "own*", "message": "{{key}} is reserved" },
+			{ "type": "denied", "key": "environ*", "code": 409 }
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+		"test_data/pod.json",
+		&settings)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	responsePayload, err := validate(payload)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	var response kubewarden_protocol.ValidationResponse
+	if err := json.Unmarshal(responsePayload, &response); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	if response.Accepted {
+		t.Fatal("Unexpected acceptance")
+	}
+	expectedMessage := "The following labels are denied: environment. ownr is reserved"
+	if *response.Message != expectedMessage {
+		t.Errorf("Unexpected rejection message: %s", *response.Message)
+	}
+	if response.Code == nil || *response.Code != 409 {
+		t.Errorf("Unexpected rejection code: %v", response.Code)
+	}
+}
+
+func TestDetectNotValidSettingsDueToDeniedKeyPatterns(t *testing.T) {
+	cases := []struct {
+		name            string
+		settings        string
+		expectedMessage string
+	}{
+		{
+			name: "mandatory label matched by a denied pattern",
+			settings: `{
+				"version": 2,
+				"rules": [
+					{ "type": "denied", "key": "example.com/*" },
+					{ "type": "mandatory", "key": "example.com/owner" }
+				]
+			}`,
+			expectedMessage: "These labels cannot be mandatory and denied at the same time: example.com/owner",
+		},
+		{
+			name: "pattern on a mandatory rule",
+			settings: `{
+				"version": 2,
+				"rules": [ { "type": "mandatory", "key": "example.com/*" } ]
+			}`,
+			expectedMessage: `/rules/0/key: "example.com/*" is not a valid label key: ` +
+				"name part must consist of alphanumeric characters, '-', '_' or '.', and must start and end with an alphanumeric character",
+		},
+		{
+			name: "too complex patterns",
+			settings: func() string {
+				rules := []string{}
+				for i := 0; i < 5000; i++ {
+					rules = append(rules, fmt.Sprintf(`{ "type": "denied", "key": "*-%d-*-tmp" }`, i))
+				}
+				return `{ "version": 2, "analysis": "off", "rules": [` + strings.Join(rules, ",") + `] }`
+			}(),
+			expectedMessage: "the denied key patterns are too complex",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			responsePayload, err := validateSettings([]byte(tc.settings))
+			if err != nil {
+				t.Fatalf("Unexpected error %+v", err)
+			}
+
+			var response kubewarden_protocol.SettingsValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			if response.Valid {
+				t.Fatal("Expected settings to not be valid")
+			}
+			expected := "Provided settings are not valid: " + tc.expectedMessage
+			if !strings.HasPrefix(*response.Message, expected) {
+				t.Errorf("Unexpected validation error message: %s", *response.Message)
+			}
+		})
+	}
+}
+
+func TestShadowedDeniedRules(t *testing.T) {
+	settings, err := NewSettingsFromValidateSettingsPayload([]byte(`
+	{
+		"version": 2,
+		"rules": [
+			{ "type": "denied", "key": "example.com/*" },
+			{ "type": "denied", "key": "example.com/team-*" },
+			{ "type": "denied", "key": "example.com/owner" },
+			{ "type": "denied", "key": "debug" }
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	expected := []string{
+		"denied rule example.com/owner is shadowed by the denied pattern example.com/*, it has no effect",
+		"denied rule example.com/team-* is shadowed by the denied pattern example.com/*, it has no effect",
+	}
+	warnings := settings.Warnings()
+	if strings.Join(warnings, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected warnings: %v", warnings)
+	}
+}
+
+// Builds a matcher made of `size` exact keys, `size` prefixes, `size`
+// glob patterns with a literal prefix and 10 glob patterns starting
+// with a wildcard
+func benchmarkKeyMatcher(size int) *keyMatcher {
+	keys := []string{}
+	for i := 0; i < size; i++ {
+		keys = append(keys,
+			fmt.Sprintf("generated-%d", i),
+			fmt.Sprintf("team-%d.example.com/*", i),
+			fmt.Sprintf("tenant-%d.example.com/*-tmp", i))
+	}
+	for i := 0; i < 10; i++ {
+		keys = append(keys, fmt.Sprintf("*.region-%d/*", i))
+	}
+	return newKeyMatcher(keys)
+}
+
+func BenchmarkKeyMatcher(b *testing.B) {
+	labels := []string{
+		"app.kubernetes.io/name",
+		"team-42.example.com/owner",
+		"tenant-42.example.com/cache-tmp",
+		"tenant-42.example.com/cache",
+		"billing.region-7/cost-center",
+		"generated-999",
+	}
+
+	for _, size := range []int{100, 1000, 5000} {
+		m := benchmarkKeyMatcher(size)
+		b.Run(fmt.Sprintf("rules=%d", size*3), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, label := range labels {
+					m.match(label)
+				}
+			}
+		})
+	}
+}
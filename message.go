@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Placeholders that can be used inside of a message template
+const (
+	PlaceholderKey           = "key"
+	PlaceholderValue         = "value"
+	PlaceholderPattern       = "pattern"
+	PlaceholderAllowedValues = "allowed_values"
+	PlaceholderNamespace     = "namespace"
+	PlaceholderKind          = "kind"
+	PlaceholderID            = "id"
+	PlaceholderSeverity      = "severity"
+	PlaceholderDocsURL       = "docs_url"
+	PlaceholderControls      = "controls"
+)
+
+var knownPlaceholders = map[string]struct{}{
+	PlaceholderKey:           {},
+	PlaceholderValue:         {},
+	PlaceholderPattern:       {},
+	PlaceholderAllowedValues: {},
+	PlaceholderNamespace:     {},
+	PlaceholderKind:          {},
+	PlaceholderID:            {},
+	PlaceholderSeverity:      {},
+	PlaceholderDocsURL:       {},
+	PlaceholderControls:      {},
+}
+
+// A piece of a message template: either a literal text or
+// the name of a placeholder
+type templatePart struct {
+	text        string
+	placeholder bool
+}
+
+// MessageTemplate is a user provided message that is shown when a
+// rule is violated. The template can reference details about the
+// violation using placeholders like `{{key}}` and `{{value}}`.
+type MessageTemplate struct {
+	raw   string
+	parts []templatePart
+}
+
+// ParseMessageTemplate builds a MessageTemplate, an error is returned
+// when the template is malformed or uses an unknown placeholder
+func ParseMessageTemplate(raw string) (*MessageTemplate, error) {
+	parts := []templatePart{}
+	rest := raw
+
+	for {
+		start := strings.Index(rest, "{{")
+		if start == -1 {
+			break
+		}
+		end := strings.Index(rest[start:], "}}")
+		if end == -1 {
+			return nil, fmt.Errorf("message template %q has an unterminated placeholder", raw)
+		}
+
+		name := strings.TrimSpace(rest[start+2 : start+end])
+		if _, known := knownPlaceholders[name]; !known {
+			return nil, fmt.Errorf(
+				"message template %q uses unknown placeholder {{%s}}, known placeholders are: %s",
+				raw, name, strings.Join(placeholderNames(), ", "))
+		}
+
+		if start > 0 {
+			parts = append(parts, templatePart{text: rest[:start]})
+		}
+		parts = append(parts, templatePart{text: name, placeholder: true})
+		rest = rest[start+end+2:]
+	}
+	if rest != "" {
+		parts = append(parts, templatePart{text: rest})
+	}
+
+	return &MessageTemplate{raw: raw, parts: parts}, nil
+}
+
+// Render produces the final message, placeholders that are not
+// part of `values` are replaced with an empty string
+func (t *MessageTemplate) Render(values map[string]string) string {
+	var b strings.Builder
+	for _, part := range t.parts {
+		if part.placeholder {
+			b.WriteString(values[part.text])
+		} else {
+			b.WriteString(part.text)
+		}
+	}
+	return b.String()
+}
+
+func (t *MessageTemplate) String() string {
+	return t.raw
+}
+
+// UnmarshalText satisfies the encoding.TextMarshaler interface,
+// also used by json.Unmarshal.
+func (t *MessageTemplate) UnmarshalText(text []byte) error {
+	parsed, err := ParseMessageTemplate(string(text))
+	if err != nil {
+		return err
+	}
+	*t = *parsed
+	return nil
+}
+
+// MarshalText satisfies the encoding.TextMarshaler interface,
+// also used by json.Marshal.
+func (t *MessageTemplate) MarshalText() ([]byte, error) {
+	return []byte(t.raw), nil
+}
+
+func placeholderNames() []string {
+	names := make([]string, 0, len(knownPlaceholders))
+	for name := range knownPlaceholders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
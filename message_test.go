@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderMessageTemplate(t *testing.T) {
+	tmpl, err := ParseMessageTemplate(
+		"add `{{key}}: team-<name>` to the {{ kind }} inside of {{namespace}}, got '{{value}}'")
+	if err != nil {
+		t.Fatalf("Unexpected error %+v", err)
+	}
+
+	msg := tmpl.Render(map[string]string{
+		PlaceholderKey:       "owner",
+		PlaceholderKind:      "Pod",
+		PlaceholderNamespace: "default",
+	})
+
+	expected := "add `owner: team-<name>` to the Pod inside of default, got ''"
+	if msg != expected {
+		t.Errorf("Got '%s' instead of '%s'", msg, expected)
+	}
+}
+
+func TestRenderMessageTemplateWithoutPlaceholders(t *testing.T) {
+	tmpl, err := ParseMessageTemplate("see https://wiki/labels")
+	if err != nil {
+		t.Fatalf("Unexpected error %+v", err)
+	}
+
+	msg := tmpl.Render(map[string]string{PlaceholderKey: "owner"})
+	if msg != "see https://wiki/labels" {
+		t.Errorf("Unexpected message: %s", msg)
+	}
+}
+
+func TestParseMessageTemplateWithUnknownPlaceholder(t *testing.T) {
+	_, err := ParseMessageTemplate("label {{label}} is not valid")
+	if err == nil {
+		t.Fatal("Didn't get expected error")
+	}
+
+	if !strings.Contains(err.Error(), "unknown placeholder {{label}}") {
+		t.Errorf("Unexpected error message: %v", err)
+	}
+}
+
+func TestParseMessageTemplateWithUnterminatedPlaceholder(t *testing.T) {
+	_, err := ParseMessageTemplate("label {{key is not valid")
+	if err == nil {
+		t.Fatal("Didn't get expected error")
+	}
+
+	if !strings.Contains(err.Error(), "unterminated placeholder") {
+		t.Errorf("Unexpected error message: %v", err)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	mapset "github.com/deckarep/golang-set/v2"
+)
+
+// The annotations a Namespace can use to declare label requirements, once
+// prefixed by the annotation prefix of the settings
+const (
+	// A comma separated list of mandatory labels
+	mandatoryAnnotation = "mandatory"
+	// The pattern constraining the value of the label whose key follows
+	// the dash
+	constrainedAnnotation = "constrained-"
+)
+
+// NamespaceRules relate the labels of an object to the ones of its
+// Namespace, which is looked up through the policy host:
+//
+//	{
+//	  "match_labels": ["tenant"],
+//	  "inherit_labels": ["owner", "cost-center"],
+//	  "annotation_prefix": "labels.policy.example.com/"
+//	}
+type NamespaceRules struct {
+	// The labels that, when set on an object, must have the same value
+	// of the homonymous labels of its Namespace
+	MatchLabels []string `json:"match_labels,omitempty"`
+	// The mandatory labels that are satisfied when they are set on
+	// the Namespace of the object
+	InheritLabels []string `json:"inherit_labels,omitempty"`
+	// The prefix of the annotations a Namespace can use to require more
+	// labels on its objects
+	AnnotationPrefix string `json:"annotation_prefix,omitempty"`
+}
+
+func (r NamespaceRules) isZero() bool {
+	return len(r.MatchLabels) == 0 && len(r.InheritLabels) == 0 && r.AnnotationPrefix == ""
+}
+
+// The label requirements declared by a Namespace, either through its
+// annotations or through tenant policies. They are enforced on top of the
+// ones of the settings
+type namespaceRequirements struct {
+	mandatory mapset.Set[string]
+	// The values of a label must match all its constraints
+	constrained map[string][]*RegularExpression
+	// The keys, or key patterns, of the denied labels
+	denied     []string
+	deniedKeys *keyMatcher
+}
+
+func newNamespaceRequirements() namespaceRequirements {
+	return namespaceRequirements{
+		mandatory:   mapset.NewThreadUnsafeSet[string](),
+		constrained: make(map[string][]*RegularExpression),
+	}
+}
+
+// Returns true when the label is denied by the requirements
+func (r *namespaceRequirements) denies(label string) bool {
+	if len(r.denied) == 0 {
+		return false
+	}
+	if r.deniedKeys == nil {
+		r.deniedKeys = newKeyMatcher(r.denied)
+	}
+	_, denied := r.deniedKeys.match(label)
+	return denied
+}
+
+// Returns true when the value of the label violates one of its constraints
+func (r *namespaceRequirements) violatesConstraints(label, value string) bool {
+	for _, re := range r.constrained[label] {
+		if !re.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reads the label requirements declared by the Namespace. Requirements
+// about labels denied by the settings are ignored: the denied rules always
+// win. Malformed annotations are reported together
+func (s *Settings) namespaceRequirements(ns *namespaceObject) (namespaceRequirements, error) {
+	requirements := newNamespaceRequirements()
+	prefix := s.Namespace.AnnotationPrefix
+	if ns == nil || prefix == "" {
+		return requirements, nil
+	}
+
+	annotations := make([]string, 0, len(ns.annotations))
+	for annotation := range ns.annotations {
+		if strings.HasPrefix(annotation, prefix) {
+			annotations = append(annotations, annotation)
+		}
+	}
+	sort.Strings(annotations)
+
+	problems := []string{}
+	for _, annotation := range annotations {
+		if err := s.addNamespaceRequirement(
+			&requirements,
+			strings.TrimPrefix(annotation, prefix),
+			ns.annotations[annotation],
+		); err != nil {
+			problems = append(problems, fmt.Sprintf("annotation %s: %v", annotation, err))
+		}
+	}
+	if len(problems) > 0 {
+		return requirements, errors.New(strings.Join(problems, "; "))
+	}
+	return requirements, nil
+}
+
+func (s *Settings) addNamespaceRequirement(requirements *namespaceRequirements, name, value string) error {
+	switch {
+	case name == mandatoryAnnotation:
+		for _, label := range strings.Split(value, ",") {
+			label = strings.TrimSpace(label)
+			if problems := labelKeyErrors(label); len(problems) > 0 {
+				return fmt.Errorf("%q is not a valid label key: %s", label, strings.Join(problems, ", "))
+			}
+			if _, denied := s.deniedRule(label); !denied {
+				requirements.mandatory.Add(label)
+			}
+		}
+	case strings.HasPrefix(name, constrainedAnnotation):
+		label := strings.TrimPrefix(name, constrainedAnnotation)
+		if problems := labelKeyErrors(label); len(problems) > 0 {
+			return fmt.Errorf("%q is not a valid label key: %s", label, strings.Join(problems, ", "))
+		}
+		if length := len(value); length > s.Limits.maxPatternLength() {
+			return fmt.Errorf("the pattern is %d characters long, the limit is %d", length, s.Limits.maxPatternLength())
+		}
+		re, err := CompileRegularExpression(value)
+		if err != nil {
+			return fmt.Errorf("invalid regex: %v", err)
+		}
+		if size := programSize(re); size > s.Limits.maxProgramSize() {
+			return fmt.Errorf("the pattern compiles to %d instructions, the limit is %d", size, s.Limits.maxProgramSize())
+		}
+		if _, denied := s.deniedRule(label); !denied {
+			requirements.constrained[label] = append(requirements.constrained[label], re)
+		}
+	default:
+		return fmt.Errorf("unknown requirement, use either %s or %s<label>", mandatoryAnnotation, constrainedAnnotation)
+	}
+	return nil
+}
+
+// Returns the Namespace of the object being validated, when some rule
+// needs it. Cluster-wide objects and Namespaces themselves don't have one
+func (s *Settings) requestNamespace(reqCtx requestContext, lookup *namespaceLookup) (*namespaceObject, error) {
+	if !s.ContextAware || s.Namespace.isZero() {
+		return nil, nil
+	}
+	if reqCtx.namespace == "" || reqCtx.kind == "Namespace" {
+		return nil, nil
+	}
+	return lookup.get(reqCtx.namespace)
+}
+
+// Returns the labels of the object whose value differs from the one of
+// the Namespace, sorted
+func (s *Settings) namespaceMatchViolations(labelValues map[string]string, ns *namespaceObject) []string {
+	violations := []string{}
+	if ns == nil {
+		return violations
+	}
+
+	for _, label := range s.Namespace.MatchLabels {
+		value, found := labelValues[label]
+		if !found {
+			continue
+		}
+		if nsValue, found := ns.labels[label]; !found || nsValue != value {
+			violations = append(violations, label)
+		}
+	}
+	sort.Strings(violations)
+	return violations
+}
+
+// Removes from the missing mandatory labels the ones that are inherited
+// from the Namespace
+func (s *Settings) withoutInheritedLabels(missing []string, ns *namespaceObject) []string {
+	if ns == nil {
+		return missing
+	}
+
+	inherited := make(map[string]struct{}, len(s.Namespace.InheritLabels))
+	for _, label := range s.Namespace.InheritLabels {
+		if _, found := ns.labels[label]; found {
+			inherited[label] = struct{}{}
+		}
+	}
+
+	stillMissing := []string{}
+	for _, label := range missing {
+		if _, found := inherited[label]; !found {
+			stillMissing = append(stillMissing, label)
+		}
+	}
+	return stillMissing
+}
+
+// Checks the Namespace rules, they need context-aware lookups
+func (s *Settings) validateNamespaceRules() []string {
+	errors := []string{}
+	if !s.Namespace.isZero() && !s.ContextAware {
+		errors = append(errors, "namespace rules require context_aware to be enabled")
+	}
+	return errors
+}
+
+// Decodes the Namespace rules
+func (d *settingsDecoder) namespaceRules(path string, raw json.RawMessage) NamespaceRules {
+	rules := NamespaceRules{}
+
+	labels := func(labels *[]string) fieldDecoder {
+		return func(path string, raw json.RawMessage) {
+			d.array(path, raw, func(path string, raw json.RawMessage) {
+				if key, ok := d.string(path, raw); ok && d.labelKey(path, key) {
+					*labels = append(*labels, key)
+				}
+			})
+		}
+	}
+
+	d.object(path, raw, fieldDecoders{
+		"match_labels":   labels(&rules.MatchLabels),
+		"inherit_labels": labels(&rules.InheritLabels),
+		"annotation_prefix": func(path string, raw json.RawMessage) {
+			prefix, ok := d.string(path, raw)
+			if !ok {
+				return
+			}
+			// the prefix must produce valid annotation keys
+			if !strings.HasSuffix(prefix, "/") || len(labelKeyErrors(prefix+mandatoryAnnotation)) > 0 {
+				d.fail(path, "%q is not a valid annotation prefix, it must be a DNS subdomain followed by /", prefix)
+				return
+			}
+			rules.AnnotationPrefix = prefix
+		},
+	})
+	return rules
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+	kubewarden_testing "github.com/kubewarden/policy-sdk-go/testing"
+)
+
+func TestNamespaceRules(t *testing.T) {
+	cases := []struct {
+		name             string
+		rules            string
+		namespaceRules   string
+		namespace        string
+		expectedAccepted bool
+		expectedMessage  string
+	}{
+		{
+			name:             "matching labels",
+			namespaceRules:   `{ "match_labels": [ "app" ] }`,
+			namespace:        `{"metadata": {"name": "payments", "labels": {"app": "web"}}}`,
+			expectedAccepted: true,
+		},
+		{
+			name:            "labels with a different value",
+			namespaceRules:  `{ "match_labels": [ "app" ] }`,
+			namespace:       `{"metadata": {"name": "payments", "labels": {"app": "api"}}}`,
+			expectedMessage: "The following labels do not match the ones of Namespace payments: app",
+		},
+		{
+			name:            "labels missing from the Namespace",
+			namespaceRules:  `{ "match_labels": [ "app" ] }`,
+			namespace:       `{"metadata": {"name": "payments"}}`,
+			expectedMessage: "The following labels do not match the ones of Namespace payments: app",
+		},
+		{
+			name:             "labels missing from the object are not matched",
+			namespaceRules:   `{ "match_labels": [ "tenant" ] }`,
+			namespace:        `{"metadata": {"name": "payments", "labels": {"tenant": "acme"}}}`,
+			expectedAccepted: true,
+		},
+		{
+			name:             "mandatory labels inherited from the Namespace",
+			rules:            `[ { "type": "mandatory", "key": "owner" } ]`,
+			namespaceRules:   `{ "inherit_labels": [ "owner" ] }`,
+			namespace:        `{"metadata": {"name": "payments", "labels": {"owner": "team-payments"}}}`,
+			expectedAccepted: true,
+		},
+		{
+			name:            "mandatory labels missing from the Namespace too",
+			rules:           `[ { "type": "mandatory", "key": "owner" } ]`,
+			namespaceRules:  `{ "inherit_labels": [ "owner" ] }`,
+			namespace:       `{"metadata": {"name": "payments"}}`,
+			expectedMessage: "The following mandatory labels are missing: owner",
+		},
+		{
+			name:            "mandatory labels declared by the Namespace",
+			namespaceRules:  `{ "annotation_prefix": "labels.policy.example.com/" }`,
+			namespace:       `{"metadata": {"name": "payments", "annotations": {"labels.policy.example.com/mandatory": "app, owner"}}}`,
+			expectedMessage: "The following mandatory labels are missing: owner",
+		},
+		{
+			name:            "constraints declared by the Namespace",
+			namespaceRules:  `{ "annotation_prefix": "labels.policy.example.com/" }`,
+			namespace:       `{"metadata": {"name": "payments", "annotations": {"labels.policy.example.com/constrained-app": "^api-"}}}`,
+			expectedMessage: "The following labels are violating user constraints: app",
+		},
+		{
+			name:             "constraints declared by the Namespace are satisfied",
+			namespaceRules:   `{ "annotation_prefix": "labels.policy.example.com/" }`,
+			namespace:        `{"metadata": {"name": "payments", "annotations": {"labels.policy.example.com/constrained-app": "^web$", "example.com/mandatory": "owner"}}}`,
+			expectedAccepted: true,
+		},
+		{
+			name:             "denied labels win over the requirements of the Namespace",
+			rules:            `[ { "type": "denied", "key": "owner" } ]`,
+			namespaceRules:   `{ "annotation_prefix": "labels.policy.example.com/" }`,
+			namespace:        `{"metadata": {"name": "payments", "annotations": {"labels.policy.example.com/mandatory": "owner"}}}`,
+			expectedAccepted: true,
+		},
+		{
+			name:           "malformed requirements declared by the Namespace",
+			namespaceRules: `{ "annotation_prefix": "labels.policy.example.com/" }`,
+			namespace: `{"metadata": {"name": "payments", "annotations": {
+				"labels.policy.example.com/constrained-app": "(",
+				"labels.policy.example.com/mandatory": "app,,owner",
+				"labels.policy.example.com/mandtory": "owner"
+			}}}`,
+			expectedMessage: "Namespace payments declares malformed label requirements: " +
+				"annotation labels.policy.example.com/constrained-app: invalid regex: error parsing regexp: missing closing ): `(`; " +
+				`annotation labels.policy.example.com/mandatory: "" is not a valid label key: name part must be non-empty; ` +
+				"annotation labels.policy.example.com/mandtory: unknown requirement, use either mandatory or constrained-<label>",
+		},
+		{
+			name:            "Namespace not found",
+			namespaceRules:  `{ "match_labels": [ "app" ] }`,
+			expectedMessage: "Cannot check the labels of the Namespace: cannot look up Namespace payments",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			objects := map[string]string{}
+			if tc.namespace != "" {
+				objects[objectKey("Namespace", "", "payments")] = tc.namespace
+			}
+			useFakeCluster(t, &fakeCluster{objects: objects})
+			rules := tc.rules
+			if rules == "" {
+				rules = "[]"
+			}
+
+			settings, err := NewSettingsFromValidateSettingsPayload([]byte(`
+			{
+				"version": 2,
+				"context_aware": true,
+				"rules": ` + rules + `,
+				"namespace": ` + tc.namespaceRules + `
+			}`))
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+			if valid, err := settings.Valid(); !valid {
+				t.Fatalf("Expected settings to be valid: %v", err)
+			}
+
+			payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+				"test_data/pod.json",
+				&settings)
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			responsePayload, err := validate(payload)
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			var response kubewarden_protocol.ValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			if response.Accepted != tc.expectedAccepted {
+				t.Fatalf("Unexpected response: %+v", response)
+			}
+			if tc.expectedAccepted {
+				return
+			}
+			if !strings.HasPrefix(*response.Message, tc.expectedMessage) {
+				t.Errorf("Unexpected rejection message: %s", *response.Message)
+			}
+		})
+	}
+}
+
+func TestNamespaceRulesAreNotCheckedOnClusterWideObjects(t *testing.T) {
+	cluster := &fakeCluster{objects: map[string]string{}}
+	useFakeCluster(t, cluster)
+
+	settings, err := NewSettingsFromValidateSettingsPayload([]byte(`
+	{
+		"version": 2,
+		"context_aware": true,
+		"namespace": { "match_labels": [ "app" ] }
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	for _, reqCtx := range []requestContext{
+		{kind: "ClusterRole"},
+		{namespace: "payments", kind: "Namespace"},
+	} {
+		ns, err := settings.requestNamespace(reqCtx, newNamespaceLookup())
+		if err != nil || ns != nil {
+			t.Errorf("Unexpected lookup for %+v: %v, %v", reqCtx, ns, err)
+		}
+	}
+	if len(cluster.calls) != 0 {
+		t.Errorf("Unexpected host calls: %v", cluster.calls)
+	}
+}
+
+func TestDetectNotValidNamespaceRules(t *testing.T) {
+	cases := []struct {
+		name            string
+		settings        string
+		expectedMessage string
+	}{
+		{
+			name: "context-aware lookups disabled",
+			settings: `{
+				"version": 2,
+				"namespace": { "inherit_labels": [ "owner" ] }
+			}`,
+			expectedMessage: "namespace rules require context_aware to be enabled",
+		},
+		{
+			name: "not valid label keys",
+			settings: `{
+				"version": 2,
+				"context_aware": true,
+				"namespace": { "match_labels": [ "-app" ] }
+			}`,
+			expectedMessage: "/namespace/match_labels/0:",
+		},
+		{
+			name: "unknown fields",
+			settings: `{
+				"version": 2,
+				"context_aware": true,
+				"namespace": { "labels": [ "app" ] }
+			}`,
+			expectedMessage: "/namespace/labels:",
+		},
+		{
+			name: "not valid annotation prefix",
+			settings: `{
+				"version": 2,
+				"context_aware": true,
+				"namespace": { "annotation_prefix": "labels.policy.example.com" }
+			}`,
+			expectedMessage: `/namespace/annotation_prefix: "labels.policy.example.com" is not a valid annotation prefix`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			responsePayload, err := validateSettings([]byte(tc.settings))
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			var response kubewarden_protocol.SettingsValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			if response.Valid {
+				t.Fatal("Expected settings to not be valid")
+			}
+			if !strings.Contains(*response.Message, tc.expectedMessage) {
+				t.Errorf("Unexpected message: %s", *response.Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/kubewarden/gjson"
+	"github.com/kubewarden/policy-sdk-go/pkg/capabilities/kubernetes"
+)
+
+// Limits of the number of owners followed through the ownerReferences
+const (
+	DefaultOwnerDepth = 3
+	MaxOwnerDepth     = 10
+)
+
+// OwnerRules relate the labels of an object to the ones of its owners,
+// found by following the ownerReferences, like the ReplicaSet and the
+// Deployment owning a Pod:
+//
+//	{
+//	  "match_labels": ["team"],
+//	  "inherit_labels": ["cost-center"],
+//	  "max_depth": 2
+//	}
+type OwnerRules struct {
+	// The labels that, when set on an object, must have the same value
+	// of the nearest owner setting them
+	MatchLabels []string `json:"match_labels,omitempty"`
+	// The mandatory labels that are satisfied when they are set on one
+	// of the owners of the object
+	InheritLabels []string `json:"inherit_labels,omitempty"`
+	// The number of owners followed, starting from the direct owner of
+	// the object. Defaults to DefaultOwnerDepth
+	MaxDepth int `json:"max_depth,omitempty"`
+	// The kinds of the owners that are looked up, the chain stops at the
+	// owners of other kinds. Defaults to defaultOwnerKinds
+	Kinds []ObjectKind `json:"kinds,omitempty"`
+}
+
+// The kinds of the owners looked up by default: the workload kinds listed
+// among the contextAwareResources of the policy
+var defaultOwnerKinds = []ObjectKind{
+	{APIVersion: "apps/v1", Kind: "Deployment"},
+	{APIVersion: "apps/v1", Kind: "ReplicaSet"},
+	{APIVersion: "apps/v1", Kind: "StatefulSet"},
+	{APIVersion: "apps/v1", Kind: "DaemonSet"},
+	{APIVersion: "batch/v1", Kind: "Job"},
+	{APIVersion: "batch/v1", Kind: "CronJob"},
+}
+
+// The cluster-wide kinds that can own namespaced objects, like the Node
+// owning the mirror Pods of the static Pods. They cannot be looked up
+// inside of the namespace of the objects they own
+var clusterScopedOwnerKinds = []ObjectKind{
+	{APIVersion: "v1", Kind: "Node"},
+}
+
+func (r OwnerRules) isZero() bool {
+	return len(r.MatchLabels) == 0 && len(r.InheritLabels) == 0 && r.MaxDepth == 0 && len(r.Kinds) == 0
+}
+
+// Returns true when the owners of the given kind are looked up
+func (r OwnerRules) followed(kind ObjectKind) bool {
+	if slices.Contains(clusterScopedOwnerKinds, kind) {
+		return false
+	}
+	if len(r.Kinds) == 0 {
+		return slices.Contains(defaultOwnerKinds, kind)
+	}
+	return slices.Contains(r.Kinds, kind)
+}
+
+func (r OwnerRules) maxDepth() int {
+	return limitOrDefault(r.MaxDepth, DefaultOwnerDepth)
+}
+
+// An owner of the object being validated, as seen by the context-aware
+// lookups
+type ownerObject struct {
+	kind   string
+	name   string
+	labels map[string]string
+}
+
+func (o ownerObject) String() string {
+	return o.kind + " " + o.name
+}
+
+// Returns the controller among the given ownerReferences, or the first
+// one when none of them is a controller
+func controllerReference(refs gjson.Result) (gjson.Result, bool) {
+	all := refs.Array()
+	for _, ref := range all {
+		if ref.Get("controller").Bool() {
+			return ref, true
+		}
+	}
+	if len(all) > 0 {
+		return all[0], true
+	}
+	return gjson.Result{}, false
+}
+
+// Returns the owners of the object, nearest first, following the
+// controller references up to the configured depth. The chain stops at
+// the first owner that doesn't exist anymore, or whose kind is not looked
+// up. Owners are looked up inside of the namespace of the object
+func (s *Settings) ownerChain(refs gjson.Result, reqCtx requestContext) ([]ownerObject, error) {
+	chain := []ownerObject{}
+	if !s.ContextAware || (len(s.Owners.MatchLabels) == 0 && len(s.Owners.InheritLabels) == 0) {
+		return chain, nil
+	}
+
+	for len(chain) < s.Owners.maxDepth() {
+		ref, found := controllerReference(refs)
+		if !found {
+			break
+		}
+		kind := ref.Get("kind").String()
+		name := ref.Get("name").String()
+		if !s.Owners.followed(ObjectKind{APIVersion: ref.Get("apiVersion").String(), Kind: kind}) {
+			break
+		}
+
+		selector := "metadata.name=" + name
+		var response []byte
+		var err error
+		if reqCtx.namespace != "" {
+			response, err = kubernetes.ListResourcesByNamespace(&host, kubernetes.ListResourcesByNamespaceRequest{
+				APIVersion:    ref.Get("apiVersion").String(),
+				Kind:          kind,
+				Namespace:     reqCtx.namespace,
+				FieldSelector: &selector,
+			})
+		} else {
+			response, err = kubernetes.ListResources(&host, kubernetes.ListAllResourcesRequest{
+				APIVersion:    ref.Get("apiVersion").String(),
+				Kind:          kind,
+				FieldSelector: &selector,
+			})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot look up %s %s: %w", kind, name, err)
+		}
+		if !gjson.ValidBytes(response) {
+			return nil, fmt.Errorf("cannot look up %s %s: the host returned a malformed list", kind, name)
+		}
+
+		items := gjson.GetBytes(response, "items").Array()
+		if len(items) == 0 {
+			break
+		}
+		chain = append(chain, ownerObject{
+			kind:   kind,
+			name:   name,
+			labels: stringMap(items[0].Get("metadata.labels")),
+		})
+		refs = items[0].Get("metadata.ownerReferences")
+	}
+	return chain, nil
+}
+
+// Returns the labels of the object whose value differs from the one of the
+// nearest owner setting them, sorted, together with the details of each
+// mismatch
+func (s *Settings) ownerMatchViolations(labelValues map[string]string, chain []ownerObject) ([]string, map[string]string) {
+	violations := []string{}
+	details := make(map[string]string)
+	if len(chain) == 0 {
+		return violations, details
+	}
+
+	for _, label := range s.Owners.MatchLabels {
+		value, found := labelValues[label]
+		if !found {
+			continue
+		}
+		matched := false
+		for _, owner := range chain {
+			ownerValue, found := owner.labels[label]
+			if !found {
+				continue
+			}
+			if ownerValue != value {
+				details[label] = fmt.Sprintf("%s has %s=%s", owner, label, ownerValue)
+			}
+			matched = ownerValue == value
+			break
+		}
+		if !matched {
+			if _, found := details[label]; !found {
+				details[label] = fmt.Sprintf("not set by %s", chain[0])
+			}
+			violations = append(violations, label)
+		}
+	}
+	sort.Strings(violations)
+	return violations, details
+}
+
+// Removes from the missing mandatory labels the ones that are inherited
+// from the owners
+func (s *Settings) withoutOwnerInheritedLabels(missing []string, chain []ownerObject) []string {
+	inherited := make(map[string]struct{}, len(s.Owners.InheritLabels))
+	for _, label := range s.Owners.InheritLabels {
+		for _, owner := range chain {
+			if _, found := owner.labels[label]; found {
+				inherited[label] = struct{}{}
+				break
+			}
+		}
+	}
+
+	stillMissing := []string{}
+	for _, label := range missing {
+		if _, found := inherited[label]; !found {
+			stillMissing = append(stillMissing, label)
+		}
+	}
+	return stillMissing
+}
+
+// Checks the owner rules, they need context-aware lookups
+func (s *Settings) validateOwnerRules() []string {
+	errors := []string{}
+	if !s.Owners.isZero() && !s.ContextAware {
+		errors = append(errors, "owner rules require context_aware to be enabled")
+	}
+	return errors
+}
+
+// Decodes the owner rules
+func (d *settingsDecoder) ownerRules(path string, raw json.RawMessage) OwnerRules {
+	rules := OwnerRules{}
+
+	labels := func(labels *[]string) fieldDecoder {
+		return func(path string, raw json.RawMessage) {
+			d.array(path, raw, func(path string, raw json.RawMessage) {
+				if key, ok := d.string(path, raw); ok && d.labelKey(path, key) {
+					*labels = append(*labels, key)
+				}
+			})
+		}
+	}
+
+	d.object(path, raw, fieldDecoders{
+		"match_labels":   labels(&rules.MatchLabels),
+		"inherit_labels": labels(&rules.InheritLabels),
+		"kinds": func(path string, raw json.RawMessage) {
+			d.array(path, raw, func(path string, raw json.RawMessage) {
+				if kind, ok := d.objectKind(path, raw); ok {
+					rules.Kinds = append(rules.Kinds, kind)
+				}
+			})
+		},
+		"max_depth": func(path string, raw json.RawMessage) {
+			if depth, ok := d.positiveInt(path, raw); ok {
+				if depth > MaxOwnerDepth {
+					d.fail(path, "must be at most %d", MaxOwnerDepth)
+					return
+				}
+				rules.MaxDepth = depth
+			}
+		},
+	})
+	return rules
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+	kubewarden_testing "github.com/kubewarden/policy-sdk-go/testing"
+)
+
+// The ReplicaSet owning the Pod of test_data/owned_pod.json, owned in
+// turn by a Deployment
+func ownerObjects(replicaSetLabels, deploymentLabels string) map[string]string {
+	return map[string]string{
+		objectKey("ReplicaSet", "payments", "web-5d8f7c9b6"): `{"metadata": {
+			"name": "web-5d8f7c9b6",
+			"labels": ` + replicaSetLabels + `,
+			"ownerReferences": [ { "apiVersion": "apps/v1", "kind": "Deployment", "name": "web", "controller": true } ]
+		}}`,
+		objectKey("Deployment", "payments", "web"): `{"metadata": {"name": "web", "labels": ` + deploymentLabels + `}}`,
+	}
+}
+
+func TestOwnerRules(t *testing.T) {
+	cases := []struct {
+		name             string
+		settings         string
+		objects          map[string]string
+		expectedAccepted bool
+		expectedMessage  string
+		expectedCalls    int
+	}{
+		{
+			name:             "labels matching the ones of the owner",
+			settings:         `"owners": { "match_labels": [ "team" ] }`,
+			objects:          ownerObjects(`{"team": "payments"}`, `{"team": "payments"}`),
+			expectedAccepted: true,
+			expectedCalls:    2,
+		},
+		{
+			name:     "labels with a different value",
+			settings: `"owners": { "match_labels": [ "team" ] }`,
+			objects:  ownerObjects(`{"team": "checkout"}`, `{"team": "payments"}`),
+			expectedMessage: "The following labels do not match the ones of their owners: " +
+				"team (ReplicaSet web-5d8f7c9b6 has team=checkout)",
+			expectedCalls: 2,
+		},
+		{
+			name:             "labels matching the ones of the nearest owner setting them",
+			settings:         `"owners": { "match_labels": [ "team" ] }`,
+			objects:          ownerObjects(`{}`, `{"team": "payments"}`),
+			expectedAccepted: true,
+			expectedCalls:    2,
+		},
+		{
+			name:     "labels missing from the owners",
+			settings: `"owners": { "match_labels": [ "team" ] }`,
+			objects:  ownerObjects(`{}`, `{}`),
+			expectedMessage: "The following labels do not match the ones of their owners: " +
+				"team (not set by ReplicaSet web-5d8f7c9b6)",
+			expectedCalls: 2,
+		},
+		{
+			name:     "owners beyond the depth limit",
+			settings: `"owners": { "match_labels": [ "team" ], "max_depth": 1 }`,
+			objects:  ownerObjects(`{}`, `{"team": "payments"}`),
+			expectedMessage: "The following labels do not match the ones of their owners: " +
+				"team (not set by ReplicaSet web-5d8f7c9b6)",
+			expectedCalls: 1,
+		},
+		{
+			name: "mandatory labels inherited from the owners",
+			settings: `"rules": [ { "type": "mandatory", "key": "cost-center" } ],
+				"owners": { "inherit_labels": [ "cost-center" ] }`,
+			objects:          ownerObjects(`{}`, `{"cost-center": "cc-42"}`),
+			expectedAccepted: true,
+			expectedCalls:    2,
+		},
+		{
+			name: "mandatory labels missing from the owners too",
+			settings: `"rules": [ { "type": "mandatory", "key": "cost-center" } ],
+				"owners": { "inherit_labels": [ "cost-center" ] }`,
+			objects:         ownerObjects(`{}`, `{}`),
+			expectedMessage: "The following mandatory labels are missing: cost-center",
+			expectedCalls:   2,
+		},
+		{
+			name:             "owners that don't exist anymore",
+			settings:         `"owners": { "match_labels": [ "team" ] }`,
+			objects:          map[string]string{},
+			expectedAccepted: true,
+			expectedCalls:    1,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cluster := &fakeCluster{objects: tc.objects}
+			useFakeCluster(t, cluster)
+
+			settings, err := NewSettingsFromValidateSettingsPayload([]byte(`
+			{
+				"version": 2,
+				"context_aware": true,
+				` + tc.settings + `
+			}`))
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+			if valid, err := settings.Valid(); !valid {
+				t.Fatalf("Expected settings to be valid: %v", err)
+			}
+
+			payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+				"test_data/owned_pod.json",
+				&settings)
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			responsePayload, err := validate(payload)
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			var response kubewarden_protocol.ValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			if len(cluster.calls) != tc.expectedCalls {
+				t.Errorf("Unexpected host calls: %v", cluster.calls)
+			}
+			if response.Accepted != tc.expectedAccepted {
+				t.Fatalf("Unexpected response: %+v", response)
+			}
+			if tc.expectedAccepted {
+				return
+			}
+			if *response.Message != tc.expectedMessage {
+				t.Errorf("Unexpected rejection message: %s", *response.Message)
+			}
+		})
+	}
+}
+
+func TestOwnerRulesFailedLookup(t *testing.T) {
+	previous := host.Client
+	host.Client = unreachableCluster{}
+	t.Cleanup(func() { host.Client = previous })
+
+	settings, err := NewSettingsFromValidateSettingsPayload([]byte(`
+	{
+		"version": 2,
+		"context_aware": true,
+		"owners": { "match_labels": [ "team" ] }
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+		"test_data/owned_pod.json",
+		&settings)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	responsePayload, err := validate(payload)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	var response kubewarden_protocol.ValidationResponse
+	if err := json.Unmarshal(responsePayload, &response); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	if response.Accepted {
+		t.Fatal("Unexpected acceptance")
+	}
+	expected := "Cannot check the labels of the owners: cannot look up ReplicaSet web-5d8f7c9b6: connection refused"
+	if *response.Message != expected {
+		t.Errorf("Unexpected rejection message: %s", *response.Message)
+	}
+}
+
+func TestOwnerRulesStopAtOwnersNotLookedUp(t *testing.T) {
+	cases := []struct {
+		name     string
+		fixture  string
+		settings string
+	}{
+		{
+			name:     "mirror Pod owned by a Node",
+			fixture:  "test_data/static_pod.json",
+			settings: `"owners": { "match_labels": [ "tier" ] }`,
+		},
+		{
+			name:    "owner kind not listed",
+			fixture: "test_data/owned_pod.json",
+			settings: `"owners": {
+				"match_labels": [ "team" ],
+				"kinds": [ { "api_version": "argoproj.io/v1alpha1", "kind": "Rollout" } ]
+			}`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// any lookup would reject the request
+			previous := host.Client
+			host.Client = unreachableCluster{}
+			t.Cleanup(func() { host.Client = previous })
+
+			settings, err := NewSettingsFromValidateSettingsPayload([]byte(`
+			{
+				"version": 2,
+				"context_aware": true,
+				` + tc.settings + `
+			}`))
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			payload, err := kubewarden_testing.BuildValidationRequestFromFixture(tc.fixture, &settings)
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			responsePayload, err := validate(payload)
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			var response kubewarden_protocol.ValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			if !response.Accepted {
+				t.Errorf("Unexpected rejection: %s", *response.Message)
+			}
+		})
+	}
+}
+
+func TestDetectNotValidOwnerRules(t *testing.T) {
+	cases := []struct {
+		name            string
+		settings        string
+		expectedMessage string
+	}{
+		{
+			name: "context-aware lookups disabled",
+			settings: `{
+				"version": 2,
+				"owners": { "match_labels": [ "team" ] }
+			}`,
+			expectedMessage: "owner rules require context_aware to be enabled",
+		},
+		{
+			name: "depth limit too high",
+			settings: `{
+				"version": 2,
+				"context_aware": true,
+				"owners": { "match_labels": [ "team" ], "max_depth": 20 }
+			}`,
+			expectedMessage: "/owners/max_depth: must be at most 10",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			responsePayload, err := validateSettings([]byte(tc.settings))
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			var response kubewarden_protocol.SettingsValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			if response.Valid {
+				t.Fatal("Expected settings to not be valid")
+			}
+			if !strings.Contains(*response.Message, tc.expectedMessage) {
+				t.Errorf("Unexpected message: %s", *response.Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/kubewarden/gjson"
+)
+
+// LabelOwner restricts the users allowed to set, change or remove the
+// labels matching some keys, like:
+//
+//	{
+//	  "keys": ["platform.example.com/*"],
+//	  "users": ["system:serviceaccount:platform:deployer"],
+//	  "groups": ["platform-team"]
+//	}
+type LabelOwner struct {
+	// Label keys or key patterns, like `example.com/*`
+	Keys []string `json:"keys"`
+	// The usernames allowed to change the labels
+	Users []string `json:"users,omitempty"`
+	// The groups whose members are allowed to change the labels
+	Groups []string `json:"groups,omitempty"`
+	// Matches the keys, which can be patterns
+	keys *keyMatcher
+}
+
+// Returns true when the owner rule covers the given label key
+func (o *LabelOwner) covers(label string) bool {
+	if o.keys == nil {
+		o.keys = newKeyMatcher(o.Keys)
+	}
+	_, covered := o.keys.match(label)
+	return covered
+}
+
+// Returns true when the user is one of the owners
+func (o *LabelOwner) allows(user userInfo) bool {
+	if slices.Contains(o.Users, user.username) {
+		return true
+	}
+	for _, group := range user.groups {
+		if slices.Contains(o.Groups, group) {
+			return true
+		}
+	}
+	return false
+}
+
+// Describes the owners, like "owned by users alice and groups platform"
+func (o *LabelOwner) describe() string {
+	owners := []string{}
+	if len(o.Users) > 0 {
+		owners = append(owners, "users "+strings.Join(o.Users, ", "))
+	}
+	if len(o.Groups) > 0 {
+		owners = append(owners, "groups "+strings.Join(o.Groups, ", "))
+	}
+	return "owned by " + strings.Join(owners, " and ")
+}
+
+// The author of the admission request
+type userInfo struct {
+	username string
+	groups   []string
+}
+
+func requestUserInfo(payload []byte) userInfo {
+	info := gjson.GetBytes(payload, "request.userInfo")
+	user := userInfo{username: info.Get("username").String()}
+	for _, group := range info.Get("groups").Array() {
+		user.groups = append(user.groups, group.String())
+	}
+	return user
+}
+
+// Returns the labels set, changed or removed by the request, sorted. All
+// the labels of the object are changed by the requests other than UPDATE
+func changedLabels(payload []byte, labelValues map[string]string) []string {
+	changed := []string{}
+	if gjson.GetBytes(payload, "request.operation").String() != "UPDATE" {
+		for label := range labelValues {
+			changed = append(changed, label)
+		}
+		sort.Strings(changed)
+		return changed
+	}
+
+	oldValues := stringMap(gjson.GetBytes(payload, "request.oldObject.metadata.labels"))
+	for label, value := range labelValues {
+		if oldValue, found := oldValues[label]; !found || oldValue != value {
+			changed = append(changed, label)
+		}
+	}
+	for label := range oldValues {
+		if _, found := labelValues[label]; !found {
+			changed = append(changed, label)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// Returns the changed labels that the user is not allowed to change,
+// sorted, together with the owners of each one of them. When a label is
+// covered by more owner rules, the user must be allowed by all of them
+func (s *Settings) labelOwnerViolations(changed []string, user userInfo) ([]string, map[string]string) {
+	violations := []string{}
+	details := make(map[string]string)
+
+	for _, label := range changed {
+		for i := range s.LabelOwners {
+			owner := &s.LabelOwners[i]
+			if owner.covers(label) && !owner.allows(user) {
+				violations = append(violations, label)
+				details[label] = owner.describe()
+				break
+			}
+		}
+	}
+	return violations, details
+}
+
+// Decodes the rules restricting the users allowed to change some labels
+func (d *settingsDecoder) labelOwner(path string, raw json.RawMessage) (LabelOwner, bool) {
+	owner := LabelOwner{}
+	errsBefore := len(d.errs)
+
+	names := func(names *[]string) fieldDecoder {
+		return func(path string, raw json.RawMessage) {
+			d.array(path, raw, func(path string, raw json.RawMessage) {
+				if name, ok := d.string(path, raw); ok {
+					if name == "" {
+						d.fail(path, "must not be empty")
+						return
+					}
+					*names = append(*names, name)
+				}
+			})
+		}
+	}
+
+	d.object(path, raw, fieldDecoders{
+		"keys": func(path string, raw json.RawMessage) {
+			d.array(path, raw, func(path string, raw json.RawMessage) {
+				// keys can be patterns, like the ones of the denied rules
+				if key, ok := d.string(path, raw); ok && d.ruleKey(path, key, DeniedRule) {
+					owner.Keys = append(owner.Keys, key)
+				}
+			})
+		},
+		"users":  names(&owner.Users),
+		"groups": names(&owner.Groups),
+	})
+	if len(d.errs) != errsBefore {
+		return owner, false
+	}
+
+	switch {
+	case len(owner.Keys) == 0:
+		d.fail(path, "needs at least one key")
+	case len(owner.Users) == 0 && len(owner.Groups) == 0:
+		d.fail(path, "needs at least one user or group")
+	}
+	owner.keys = newKeyMatcher(owner.Keys)
+	return owner, len(d.errs) == errsBefore
+}
+
+// Checks that each key is listed by a single owner rule, and that the
+// key patterns are not too complex: all of them can be matched against
+// each changed label
+func (s *Settings) validateLabelOwners() []string {
+	errors := []string{}
+	seen := make(map[string]struct{})
+	programSize := 0
+	for _, owner := range s.LabelOwners {
+		keys := owner.keys
+		if keys == nil {
+			keys = newKeyMatcher(owner.Keys)
+		}
+		programSize += keys.programSize
+		for _, key := range owner.Keys {
+			if _, found := seen[key]; found {
+				errors = append(errors, fmt.Sprintf("label_owners lists key %s more than once", key))
+			}
+			seen[key] = struct{}{}
+		}
+	}
+	if programSize > s.Limits.maxKeyPatternsProgramSize() {
+		errors = append(errors, fmt.Sprintf(
+			"the label owner key patterns are too complex: they compile to %d instructions, the limit is %d",
+			programSize, s.Limits.maxKeyPatternsProgramSize()))
+	}
+	return errors
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+	kubewarden_testing "github.com/kubewarden/policy-sdk-go/testing"
+)
+
+func TestLabelOwners(t *testing.T) {
+	cases := []struct {
+		name             string
+		fixture          string
+		owners           string
+		expectedAccepted bool
+		expectedMessage  string
+	}{
+		{
+			name:    "label set by another user",
+			fixture: "test_data/pod.json",
+			owners:  `[ { "keys": [ "app" ], "users": [ "alice" ] } ]`,
+			expectedMessage: "The following labels can be changed only by their owners: " +
+				"app (owned by users alice)",
+		},
+		{
+			name:             "label set by the owner",
+			fixture:          "test_data/pod.json",
+			owners:           `[ { "keys": [ "app" ], "users": [ "alice", "bob" ] } ]`,
+			expectedAccepted: true,
+		},
+		{
+			name:             "label set by a member of the owner group",
+			fixture:          "test_data/pod.json",
+			owners:           `[ { "keys": [ "app" ], "groups": [ "developers" ] } ]`,
+			expectedAccepted: true,
+		},
+		{
+			name:    "user allowed by only one of the matching rules",
+			fixture: "test_data/pod.json",
+			owners: `[
+				{ "keys": [ "ap*" ], "groups": [ "developers" ] },
+				{ "keys": [ "app" ], "groups": [ "platform" ] }
+			]`,
+			expectedMessage: "The following labels can be changed only by their owners: " +
+				"app (owned by groups platform)",
+		},
+		{
+			name:             "owned label left unchanged",
+			fixture:          "test_data/pod_update.json",
+			owners:           `[ { "keys": [ "platform.example.com/tier" ], "groups": [ "platform" ] } ]`,
+			expectedAccepted: true,
+		},
+		{
+			name:    "owned label changed",
+			fixture: "test_data/pod_update.json",
+			owners:  `[ { "keys": [ "platform.example.com/cost" ], "groups": [ "platform" ] } ]`,
+			expectedMessage: "The following labels can be changed only by their owners: " +
+				"platform.example.com/cost (owned by groups platform)",
+		},
+		{
+			name:    "owned labels changed and removed",
+			fixture: "test_data/pod_update.json",
+			owners:  `[ { "keys": [ "platform.example.com/*" ], "users": [ "alice" ], "groups": [ "platform" ] } ]`,
+			expectedMessage: "The following labels can be changed only by their owners: " +
+				"platform.example.com/cost (owned by users alice and groups platform)," +
+				"platform.example.com/zone (owned by users alice and groups platform)",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			settings, err := NewSettingsFromValidateSettingsPayload([]byte(`
+			{
+				"version": 2,
+				"label_owners": ` + tc.owners + `
+			}`))
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+			if valid, err := settings.Valid(); !valid {
+				t.Fatalf("Expected settings to be valid: %v", err)
+			}
+
+			payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+				tc.fixture,
+				&settings)
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			responsePayload, err := validate(payload)
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			var response kubewarden_protocol.ValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			if response.Accepted != tc.expectedAccepted {
+				t.Fatalf("Unexpected response: %+v", response)
+			}
+			if tc.expectedAccepted {
+				return
+			}
+			if *response.Message != tc.expectedMessage {
+				t.Errorf("Unexpected rejection message: %s", *response.Message)
+			}
+		})
+	}
+}
+
+func TestDetectNotValidLabelOwners(t *testing.T) {
+	cases := []struct {
+		name            string
+		owners          string
+		limits          string
+		expectedMessage string
+	}{
+		{
+			name:            "missing keys",
+			owners:          `[ { "users": [ "alice" ] } ]`,
+			expectedMessage: "/label_owners/0: needs at least one key",
+		},
+		{
+			name:            "missing owners",
+			owners:          `[ { "keys": [ "app" ] } ]`,
+			expectedMessage: "/label_owners/0: needs at least one user or group",
+		},
+		{
+			name:            "not valid key pattern",
+			owners:          `[ { "keys": [ "-platform/*" ], "users": [ "alice" ] } ]`,
+			expectedMessage: `/label_owners/0/keys/0: "-platform/*" is not a valid label key pattern`,
+		},
+		{
+			name: "key listed twice",
+			owners: `[
+				{ "keys": [ "app" ], "users": [ "alice" ] },
+				{ "keys": [ "app" ], "groups": [ "platform" ] }
+			]`,
+			expectedMessage: "label_owners lists key app more than once",
+		},
+		{
+			name: "key patterns too complex",
+			owners: `[
+				{ "keys": [ "*-owner" ], "users": [ "alice" ] },
+				{ "keys": [ "*-approver" ], "groups": [ "platform" ] }
+			]`,
+			limits:          `{ "max_key_patterns_program_size": 10 }`,
+			expectedMessage: "the label owner key patterns are too complex: they compile to",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			limits := "{}"
+			if tc.limits != "" {
+				limits = tc.limits
+			}
+			responsePayload, err := validateSettings([]byte(`
+			{
+				"version": 2,
+				"limits": ` + limits + `,
+				"label_owners": ` + tc.owners + `
+			}`))
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			var response kubewarden_protocol.SettingsValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			if response.Valid {
+				t.Fatal("Expected settings to not be valid")
+			}
+			if !strings.Contains(*response.Message, tc.expectedMessage) {
+				t.Errorf("Unexpected message: %s", *response.Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+)
+
+// Names of the built-in presets
+const (
+	KubernetesRecommendedPreset = "kubernetes-recommended"
+	CostAllocationPreset        = "cost-allocation"
+	OwnershipPreset             = "ownership"
+)
+
+// Matches values made of lowercase alphanumeric characters and dashes,
+// like the names of most Kubernetes objects
+const dnsLabelPattern = "^[a-z0-9]([-a-z0-9]*[a-z0-9])?$"
+
+const kubernetesRecommendedLabelsDocs = "https://kubernetes.io/docs/concepts/overview/working-with-objects/common-labels/"
+
+func presetPattern(expr string) *RegularExpression {
+	return &RegularExpression{regexp.MustCompile(expr)}
+}
+
+// Presets are named sets of rules shipped with the policy, they implement
+// widespread labeling conventions
+var presets = map[string][]Rule{
+	KubernetesRecommendedPreset: kubernetesRecommendedRules(),
+	CostAllocationPreset: {
+		{Type: MandatoryRule, Key: "cost-center"},
+		{Type: MandatoryRule, Key: "team"},
+		{Type: MandatoryRule, Key: "environment"},
+		{Type: ConstrainedRule, Key: "cost-center", Pattern: presetPattern("^cc-[0-9]+$")},
+		{Type: ConstrainedRule, Key: "team", Pattern: presetPattern(dnsLabelPattern)},
+		{
+			Type:          ConstrainedRule,
+			Key:           "environment",
+			AllowedValues: []string{"production", "staging", "development"},
+			Pattern:       allowedValuesRegularExpression([]string{"production", "staging", "development"}),
+		},
+	},
+	OwnershipPreset: {
+		{Type: MandatoryRule, Key: "owner"},
+		{Type: ConstrainedRule, Key: "owner", Pattern: presetPattern(dnsLabelPattern)},
+	},
+}
+
+// The labels recommended by the Kubernetes documentation. The name and
+// the instance of the application are required, all of them must have
+// well formed values
+func kubernetesRecommendedRules() []Rule {
+	options := RuleOptions{DocsURL: kubernetesRecommendedLabelsDocs}
+	rules := []Rule{
+		{Type: MandatoryRule, Key: "app.kubernetes.io/name", RuleOptions: options},
+		{Type: MandatoryRule, Key: "app.kubernetes.io/instance", RuleOptions: options},
+	}
+
+	for _, name := range []string{"name", "instance", "component", "part-of", "managed-by"} {
+		rules = append(rules, Rule{
+			Type:        ConstrainedRule,
+			Key:         "app.kubernetes.io/" + name,
+			Pattern:     presetPattern(dnsLabelPattern),
+			RuleOptions: options,
+		})
+	}
+	rules = append(rules, Rule{
+		Type:        ConstrainedRule,
+		Key:         "app.kubernetes.io/version",
+		Pattern:     presetPattern("^[0-9A-Za-z]([-.0-9A-Za-z_]*[0-9A-Za-z])?$"),
+		RuleOptions: options,
+	})
+
+	return rules
+}
+
+func presetNames() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Expands the given presets into rules, which are merged with the ones
+// written explicitly by the user. Explicit rules win: they replace the
+// preset rules of the same type and key, while an explicit denied rule
+// drops all the preset rules about the same key
+func expandPresets(names []string, explicit []Rule) []Rule {
+	if len(names) == 0 {
+		return explicit
+	}
+
+	overridden := make(map[RuleRef]struct{}, len(explicit))
+	denied := make(map[string]struct{})
+	for _, rule := range explicit {
+		overridden[RuleRef{ruleTypeCategories[rule.Type], rule.Key}] = struct{}{}
+		if rule.Type == DeniedRule {
+			denied[rule.Key] = struct{}{}
+		}
+	}
+
+	rules := []Rule{}
+	for _, name := range names {
+		for _, rule := range presets[name] {
+			ref := RuleRef{ruleTypeCategories[rule.Type], rule.Key}
+			if _, found := overridden[ref]; found {
+				continue
+			}
+			if _, found := denied[rule.Key]; found {
+				continue
+			}
+			// presets can overlap, the first one wins
+			overridden[ref] = struct{}{}
+			rules = append(rules, rule)
+		}
+	}
+
+	return append(rules, explicit...)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+)
+
+func TestPresetsAreValid(t *testing.T) {
+	for _, name := range presetNames() {
+		settings := Settings{}
+		for _, rule := range presets[name] {
+			settings.addRule(rule)
+		}
+		if valid, err := settings.Valid(); !valid {
+			t.Errorf("Preset %s is not valid: %v", name, err)
+		}
+		if problems := settings.analysisErrors(); len(problems) > 0 {
+			t.Errorf("Preset %s has problems: %v", name, problems)
+		}
+	}
+}
+
+func TestExpandPresets(t *testing.T) {
+	settings, err := NewSettingsFromValidateSettingsPayload([]byte(`
+	{
+		"version": 2,
+		"presets": [ "kubernetes-recommended", "ownership" ],
+		"rules": [
+			{ "type": "denied", "key": "app.kubernetes.io/instance" },
+			{ "type": "constrained", "key": "owner", "pattern": "^team-" }
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	if !settings.MandatoryLabels.Contains("app.kubernetes.io/name") || !settings.MandatoryLabels.Contains("owner") {
+		t.Errorf("Preset rules are missing: %v", settings.MandatoryLabels)
+	}
+	if settings.MandatoryLabels.Contains("app.kubernetes.io/instance") {
+		t.Error("Explicit denied rule should drop the preset rules about the same key")
+	}
+	if _, found := settings.ConstrainedLabels["app.kubernetes.io/instance"]; found {
+		t.Error("Explicit denied rule should drop the preset constraint about the same key")
+	}
+	if re := settings.ConstrainedLabels["owner"]; re == nil || re.String() != "^team-" {
+		t.Errorf("Explicit constraint should override the preset one: %v", re)
+	}
+	options := settings.RuleOptions(RuleRef{MandatoryLabelsRule, "app.kubernetes.io/name"})
+	if options.DocsURL != kubernetesRecommendedLabelsDocs {
+		t.Errorf("Unexpected rule options: %+v", options)
+	}
+	if valid, err := settings.Valid(); !valid {
+		t.Errorf("Expected settings to be valid: %v", err)
+	}
+}
+
+func TestDetectNotValidSettingsDueToUnknownPreset(t *testing.T) {
+	request := `
+	{
+		"version": 2,
+		"presets": [ "kubernetes-recomended", "security" ]
+	}
+	`
+	responsePayload, err := validateSettings([]byte(request))
+	if err != nil {
+		t.Errorf("Unexpected error %+v", err)
+	}
+
+	var response kubewarden_protocol.SettingsValidationResponse
+	if err := json.Unmarshal(responsePayload, &response); err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+
+	if response.Valid {
+		t.Error("Expected settings to not be valid")
+	}
+
+	expectedErrorMsg := "Provided settings are not valid: " +
+		"/presets/0: unknown preset \"kubernetes-recomended\", did you mean \"kubernetes-recommended\"?; " +
+		"/presets/1: unknown preset \"security\", known presets are: cost-allocation, kubernetes-recommended, ownership"
+	if *response.Message != expectedErrorMsg {
+		t.Errorf("Unexpected validation error message: %s", *response.Message)
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	mapset "github.com/deckarep/golang-set/v2"
+)
+
+// Profile is a named set of changes applied on top of the rules of the
+// settings:
+//
+//	{
+//	  "extends": ["baseline"],
+//	  "rules": [{ "type": "mandatory", "key": "owner" }],
+//	  "remove": [{ "type": "denied", "key": "debug" }]
+//	}
+//
+// A profile starts from the rules of the profiles it extends, in order,
+// or from the top-level rules when it doesn't extend any profile. Then the
+// rules listed inside of `remove` are dropped and the ones listed inside of
+// `rules` are added, replacing the existing rules with the same type and key.
+// A profile can also replace the allowlist of the settings
+type Profile struct {
+	Extends       []string       `json:"extends,omitempty"`
+	Rules         []Rule         `json:"rules,omitempty"`
+	Remove        []RuleRemoval  `json:"remove,omitempty"`
+	AllowedLabels *AllowedLabels `json:"allowed_labels,omitempty"`
+}
+
+// RuleRemoval identifies a rule that is dropped by a profile
+type RuleRemoval struct {
+	Type RuleType `json:"type"`
+	Key  string   `json:"key"`
+}
+
+func (r RuleRemoval) ref() RuleRef {
+	return RuleRef{ruleTypeCategories[r.Type], r.Key}
+}
+
+// ProfileSelector chooses the profile used by the admission requests
+// targeting the given namespaces. Namespaces are selected either by name
+// or, when context-aware lookups are enabled, by their labels
+type ProfileSelector struct {
+	Profile         string            `json:"profile"`
+	Namespaces      []string          `json:"namespaces,omitempty"`
+	NamespaceLabels map[string]string `json:"namespace_labels,omitempty"`
+}
+
+// Returns true when the selector matches the namespace. The labels of the
+// Namespace are looked up only when needed
+func (ps ProfileSelector) matches(namespace string, lookup *namespaceLookup) (bool, error) {
+	for _, name := range ps.Namespaces {
+		if name == namespace {
+			return true, nil
+		}
+	}
+	if len(ps.NamespaceLabels) == 0 || lookup == nil {
+		return false, nil
+	}
+
+	ns, err := lookup.get(namespace)
+	if err != nil {
+		return false, err
+	}
+	for key, value := range ps.NamespaceLabels {
+		if actual, found := ns.labels[key]; !found || actual != value {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Returns the settings to be used for an admission request targeting the
+// given namespace, together with the name of the selected profile. The
+// settings themselves are returned when no profile is selected
+func (s *Settings) forNamespace(namespace string, lookup *namespaceLookup) (*Settings, string, error) {
+	if namespace == "" {
+		// cluster-wide objects
+		return s, "", nil
+	}
+	if !s.ContextAware {
+		lookup = nil
+	}
+
+	for _, selector := range s.ProfileSelectors {
+		matches, err := selector.matches(namespace, lookup)
+		if err != nil {
+			return nil, "", err
+		}
+		if matches {
+			return s.effectiveProfiles[selector.Profile], selector.Profile, nil
+		}
+	}
+	return s, "", nil
+}
+
+// EffectiveSettings returns the settings produced by the given profile,
+// once all the profiles it extends have been applied
+func (s *Settings) EffectiveSettings(profile string) (*Settings, error) {
+	effective, found := s.effectiveProfiles[profile]
+	if !found {
+		return nil, fmt.Errorf("unknown profile %q", profile)
+	}
+	return effective, nil
+}
+
+// Prints the effective settings of each profile, using the v2 schema
+func (s *Settings) logEffectiveSettings() {
+	for _, name := range s.profileNames() {
+		effective, found := s.effectiveProfiles[name]
+		if !found {
+			continue
+		}
+		logEvent(LogLevelDebug, "effective settings of profile", map[string]interface{}{
+			"profile":  name,
+			"settings": effective,
+		})
+	}
+}
+
+// Returns the names of the profiles, sorted
+func (s *Settings) profileNames() []string {
+	names := make([]string, 0, len(s.Profiles))
+	for name := range s.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Builds new settings that share the configuration of `s`, but
+// enforce the given rules
+func (s *Settings) withRules(rules []Rule) *Settings {
+	settings := &Settings{
+		DeniedLabels:         mapset.NewThreadUnsafeSet[string](),
+		MandatoryLabels:      mapset.NewThreadUnsafeSet[string](),
+		Codes:                s.Codes,
+		RejectNearMissLabels: s.RejectNearMissLabels,
+		CheckLabelSyntax:     s.CheckLabelSyntax,
+		Analysis:             s.Analysis,
+		Version:              s.Version,
+		Limits:               s.Limits,
+		ContextAware:         s.ContextAware,
+		Namespace:            s.Namespace,
+		Owners:               s.Owners,
+		LabelOwners:          s.LabelOwners,
+		Conditions:           s.Conditions,
+		AllowedLabels:        s.AllowedLabels,
+		StickyLabels:         s.StickyLabels,
+		TenantPolicies:       s.TenantPolicies,
+	}
+	settings.addRules(rules)
+	settings.compileKeyMatchers()
+	return settings
+}
+
+// Applies the removals and then the rules of a profile to the given rules
+func overlayRules(base []Rule, removals []RuleRef, overlay []Rule) []Rule {
+	dropped := make(map[RuleRef]struct{}, len(removals)+len(overlay))
+	for _, ref := range removals {
+		dropped[ref] = struct{}{}
+	}
+	for _, rule := range overlay {
+		dropped[rule.ref()] = struct{}{}
+	}
+
+	rules := []Rule{}
+	for _, rule := range base {
+		if _, found := dropped[rule.ref()]; !found {
+			rules = append(rules, rule)
+		}
+	}
+	return append(rules, overlay...)
+}
+
+// Computes the effective settings of all the profiles. Profiles extending
+// unknown profiles or extending themselves, directly or through other
+// profiles, are reported together with the paths of the offending values
+func (d *settingsDecoder) resolveProfiles(s *Settings, path string) {
+	s.effectiveProfiles = make(map[string]*Settings, len(s.Profiles))
+	rules := make(map[string][]Rule, len(s.Profiles))
+	resolving := make(map[string]bool)
+
+	var resolve func(name string, stack []string) ([]Rule, bool)
+	resolve = func(name string, stack []string) ([]Rule, bool) {
+		if resolved, found := rules[name]; found {
+			return resolved, resolved != nil
+		}
+		profilePath := jsonPointer(path, name)
+		if resolving[name] {
+			cycle := append(append([]string{}, stack[slices.Index(stack, name):]...), name)
+			d.fail(jsonPointer(profilePath, "extends"), "circular extends: %s", strings.Join(cycle, " -> "))
+			return nil, false
+		}
+		resolving[name] = true
+		defer delete(resolving, name)
+
+		profile := s.Profiles[name]
+		base := s.Rules()
+		ok := true
+		if len(profile.Extends) > 0 {
+			base = []Rule{}
+			for i, parent := range profile.Extends {
+				if _, found := s.Profiles[parent]; !found {
+					d.fail(jsonPointer(profilePath, "extends", fmt.Sprint(i)), "unknown profile %q", parent)
+					ok = false
+					continue
+				}
+				parentRules, parentOK := resolve(parent, append(stack, name))
+				ok = ok && parentOK
+				base = overlayRules(base, nil, parentRules)
+			}
+		}
+
+		present := make(map[RuleRef]struct{}, len(base))
+		for _, rule := range base {
+			present[rule.ref()] = struct{}{}
+		}
+		removals := make([]RuleRef, 0, len(profile.Remove))
+		for i, removal := range profile.Remove {
+			if _, found := present[removal.ref()]; !found {
+				d.fail(jsonPointer(profilePath, "remove", fmt.Sprint(i)),
+					"there is no %s rule for label %s to remove", removal.Type, removal.Key)
+				ok = false
+			}
+			removals = append(removals, removal.ref())
+		}
+
+		if !ok {
+			rules[name] = nil
+			return nil, false
+		}
+		rules[name] = overlayRules(base, removals, profile.Rules)
+		return rules[name], true
+	}
+
+	for _, name := range s.profileNames() {
+		if resolved, ok := resolve(name, []string{}); ok {
+			effective := s.withRules(resolved)
+			if allowed := s.profileAllowedLabels(name, map[string]bool{}); allowed != nil {
+				effective.AllowedLabels = allowed
+				effective.compileAllowedKeys()
+			}
+			s.effectiveProfiles[name] = effective
+		}
+	}
+}
+
+// Checks the effective settings of each profile. Only the problems
+// introduced by the profile are reported, the ones of the settings are
+// already part of `reported`
+func (s *Settings) validateProfiles(reported []string) []string {
+	errors := []string{}
+
+	for _, name := range s.profileNames() {
+		effective, found := s.effectiveProfiles[name]
+		if !found {
+			continue
+		}
+		for _, problem := range effective.validationErrors(s) {
+			if !slices.Contains(reported, problem) {
+				errors = append(errors, fmt.Sprintf("%s: %s", jsonPointer("/profiles", name), problem))
+			}
+		}
+	}
+	return errors
+}
+
+// Checks the profile selectors
+func (s *Settings) validateProfileSelectors() []string {
+	errors := []string{}
+
+	for i, selector := range s.ProfileSelectors {
+		if len(selector.NamespaceLabels) > 0 && !s.ContextAware {
+			errors = append(errors, fmt.Sprintf(
+				"profile selector %d uses namespace_labels, which requires context_aware to be enabled", i))
+		}
+	}
+
+	return errors
+}
+
+// Decodes a profile
+func (d *settingsDecoder) profile(path string, raw json.RawMessage) (Profile, bool) {
+	profile := Profile{}
+	selectedPresets := []string{}
+	errsBefore := len(d.errs)
+
+	d.object(path, raw, fieldDecoders{
+		"extends": func(path string, raw json.RawMessage) {
+			profile.Extends, _ = d.stringList(path, raw)
+		},
+		"presets": func(path string, raw json.RawMessage) {
+			selectedPresets = d.presets(path, raw)
+		},
+		"rules": func(path string, raw json.RawMessage) {
+			profile.Rules = d.rules(path, raw)
+		},
+		"remove": func(path string, raw json.RawMessage) {
+			d.array(path, raw, func(path string, raw json.RawMessage) {
+				if removal, ok := d.ruleRemoval(path, raw); ok {
+					profile.Remove = append(profile.Remove, removal)
+				}
+			})
+		},
+		"allowed_labels": func(path string, raw json.RawMessage) {
+			profile.AllowedLabels, _ = d.allowedLabels(path, raw)
+		},
+	})
+	profile.Rules = expandPresets(selectedPresets, profile.Rules)
+
+	return profile, len(d.errs) == errsBefore
+}
+
+func (d *settingsDecoder) ruleRemoval(path string, raw json.RawMessage) (RuleRemoval, bool) {
+	removal := RuleRemoval{}
+	errsBefore := len(d.errs)
+
+	d.object(path, raw, fieldDecoders{
+		"type": func(path string, raw json.RawMessage) {
+			removal.Type, _ = d.ruleType(path, raw)
+		},
+		"key": func(path string, raw json.RawMessage) {
+			removal.Key, _ = d.string(path, raw)
+		},
+	})
+	if len(d.errs) != errsBefore {
+		return removal, false
+	}
+
+	switch {
+	case removal.Type == "":
+		d.fail(path, "missing required field type")
+	case removal.Key == "":
+		d.fail(path, "missing required field key")
+	}
+	return removal, len(d.errs) == errsBefore
+}
+
+// Decodes a profile selector
+func (d *settingsDecoder) profileSelector(path string, raw json.RawMessage) (ProfileSelector, bool) {
+	selector := ProfileSelector{}
+	errsBefore := len(d.errs)
+
+	d.object(path, raw, fieldDecoders{
+		"profile": func(path string, raw json.RawMessage) {
+			selector.Profile, _ = d.string(path, raw)
+		},
+		"namespaces": func(path string, raw json.RawMessage) {
+			selector.Namespaces, _ = d.stringList(path, raw)
+		},
+		"namespace_labels": func(path string, raw json.RawMessage) {
+			selector.NamespaceLabels = make(map[string]string)
+			d.entries(path, raw, func(path, key string, raw json.RawMessage) {
+				if value, ok := d.string(path, raw); ok {
+					selector.NamespaceLabels[key] = value
+				}
+			})
+		},
+	})
+	if len(d.errs) != errsBefore {
+		return selector, false
+	}
+
+	switch {
+	case selector.Profile == "":
+		d.fail(path, "missing required field profile")
+	case len(selector.Namespaces) == 0 && len(selector.NamespaceLabels) == 0:
+		d.fail(path, "needs either namespaces or namespace_labels")
+	}
+	return selector, len(d.errs) == errsBefore
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+	kubewarden_testing "github.com/kubewarden/policy-sdk-go/testing"
+)
+
+const profilesSettings = `
+{
+	"version": 2,
+	"rules": [
+		{ "type": "denied", "key": "debug" },
+		{ "type": "mandatory", "key": "app" },
+		{ "type": "constrained", "key": "environment", "pattern": "^[a-z]+$" }
+	],
+	"profiles": {
+		"baseline": {
+			"rules": [ { "type": "mandatory", "key": "owner" } ]
+		},
+		"production": {
+			"extends": [ "baseline" ],
+			"rules": [
+				{ "type": "constrained", "key": "environment", "allowed_values": [ "prod" ] }
+			],
+			"remove": [ { "type": "denied", "key": "debug" } ]
+		}
+	},
+	"profile_selectors": [
+		{ "profile": "production", "namespaces": [ "payments" ] }
+	]
+}`
+
+func TestProfileEffectiveSettings(t *testing.T) {
+	settings, err := NewSettingsFromValidateSettingsPayload([]byte(profilesSettings))
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if valid, err := settings.Valid(); !valid {
+		t.Fatalf("Expected settings to be valid: %v", err)
+	}
+
+	effective, err := settings.EffectiveSettings("production")
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	actual, err := json.Marshal(effective)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	expected := `{"version":2,"rules":[` +
+		`{"type":"mandatory","key":"app"},` +
+		`{"type":"mandatory","key":"owner"},` +
+		`{"type":"constrained","key":"environment","allowed_values":["prod"]}]}`
+	if string(actual) != expected {
+		t.Errorf("Unexpected effective settings: %s", actual)
+	}
+
+	if _, err := settings.EffectiveSettings("staging"); err == nil {
+		t.Error("Expected an error")
+	}
+}
+
+func TestProfileSelectedByNamespaceName(t *testing.T) {
+	settings, err := NewSettingsFromValidateSettingsPayload([]byte(profilesSettings))
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+		"test_data/pod.json",
+		&settings)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	responsePayload, err := validate(payload)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	var response kubewarden_protocol.ValidationResponse
+	if err := json.Unmarshal(responsePayload, &response); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	if response.Accepted {
+		t.Fatal("Unexpected acceptance")
+	}
+	expectedMessage := "The following labels are violating user constraints: environment. " +
+		"The following mandatory labels are missing: owner. " +
+		`Did you mean "owner" instead of "ownr"? Did you mean environment=prod instead of environment=prdo?`
+	if *response.Message != expectedMessage {
+		t.Errorf("Unexpected rejection message: %s", *response.Message)
+	}
+}
+
+func TestProfileSelectedByNamespaceLabels(t *testing.T) {
+	cases := []struct {
+		name             string
+		namespaceLabels  string
+		expectedAccepted bool
+	}{
+		{"matching labels", `{"env": "production"}`, false},
+		{"other labels", `{"env": "staging"}`, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			useFakeCluster(t, &fakeCluster{objects: map[string]string{
+				objectKey("Namespace", "", "payments"): `{"metadata": {"name": "payments", "labels": ` + tc.namespaceLabels + `}}`,
+			}})
+
+			settings, err := NewSettingsFromValidateSettingsPayload([]byte(`
+			{
+				"version": 2,
+				"context_aware": true,
+				"profiles": {
+					"production": { "rules": [ { "type": "mandatory", "key": "owner" } ] }
+				},
+				"profile_selectors": [
+					{ "profile": "production", "namespace_labels": { "env": "production" } }
+				]
+			}`))
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+				"test_data/pod.json",
+				&settings)
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			responsePayload, err := validate(payload)
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			var response kubewarden_protocol.ValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			if response.Accepted != tc.expectedAccepted {
+				t.Errorf("Unexpected response: %+v", response)
+			}
+		})
+	}
+}
+
+func TestDetectNotValidProfiles(t *testing.T) {
+	cases := []struct {
+		name            string
+		settings        string
+		expectedMessage string
+	}{
+		{
+			name: "circular extends",
+			settings: `{
+				"version": 2,
+				"profiles": {
+					"a": { "extends": [ "b" ] },
+					"b": { "extends": [ "c" ] },
+					"c": { "extends": [ "a" ] }
+				}
+			}`,
+			expectedMessage: "/profiles/a/extends: circular extends: a -> b -> c -> a",
+		},
+		{
+			name: "unknown profiles",
+			settings: `{
+				"version": 2,
+				"profiles": { "a": { "extends": [ "baseline" ] } },
+				"profile_selectors": [ { "profile": "b", "namespaces": [ "default" ] } ]
+			}`,
+			expectedMessage: `/profiles/a/extends/0: unknown profile "baseline"; ` +
+				`/profile_selectors/0/profile: unknown profile "b"`,
+		},
+		{
+			name: "removal of a missing rule",
+			settings: `{
+				"version": 2,
+				"profiles": { "a": { "remove": [ { "type": "denied", "key": "debug" } ] } }
+			}`,
+			expectedMessage: "/profiles/a/remove/0: there is no denied rule for label debug to remove",
+		},
+		{
+			name: "profiles require version 2",
+			settings: `{
+				"profiles": { "a": {} }
+			}`,
+			expectedMessage: "/profiles: requires version 2",
+		},
+		{
+			name: "conflicting effective settings",
+			settings: `{
+				"version": 2,
+				"rules": [ { "type": "denied", "key": "debug" } ],
+				"profiles": { "a": { "rules": [ { "type": "mandatory", "key": "debug" } ] } }
+			}`,
+			expectedMessage: "/profiles/a: These labels cannot be mandatory and denied at the same time: debug",
+		},
+		{
+			name: "namespace labels without context-aware lookups",
+			settings: `{
+				"version": 2,
+				"profiles": { "a": {} },
+				"profile_selectors": [ { "profile": "a", "namespace_labels": { "env": "prod" } } ]
+			}`,
+			expectedMessage: "profile selector 0 uses namespace_labels, which requires context_aware to be enabled",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			responsePayload, err := validateSettings([]byte(tc.settings))
+			if err != nil {
+				t.Fatalf("Unexpected error %+v", err)
+			}
+
+			var response kubewarden_protocol.SettingsValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			if response.Valid {
+				t.Fatal("Expected settings to not be valid")
+			}
+			expected := "Provided settings are not valid: " + tc.expectedMessage
+			if *response.Message != expected {
+				t.Errorf("Unexpected validation error message: %s", *response.Message)
+			}
+		})
+	}
+}
+
+func TestProfilesDoNotRepeatTheProblemsOfTheSettings(t *testing.T) {
+	responsePayload, err := validateSettings([]byte(`
+	{
+		"version": 2,
+		"rules": [
+			{ "type": "denied", "key": "debug" },
+			{ "type": "mandatory", "key": "debug" }
+		],
+		"profiles": {
+			"a": {},
+			"b": { "rules": [ { "type": "mandatory", "key": "trace" }, { "type": "denied", "key": "trace" } ] }
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	var response kubewarden_protocol.SettingsValidationResponse
+	if err := json.Unmarshal(responsePayload, &response); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	if response.Valid {
+		t.Fatal("Expected settings to not be valid")
+	}
+	if count := strings.Count(*response.Message, "debug"); count != 1 {
+		t.Errorf("Expected the problem of the settings to be reported once: %s", *response.Message)
+	}
+	if !strings.Contains(*response.Message, "/profiles/b: These labels cannot be mandatory and denied at the same time: trace") {
+		t.Errorf("Unexpected message: %s", *response.Message)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/kubewarden/gjson"
+	"github.com/kubewarden/policy-sdk-go/pkg/capabilities/kubernetes"
+)
+
+// Where the objects referenced by a label are looked up
+const (
+	// Among the cluster-wide objects
+	ReferenceScopeCluster = "cluster"
+	// Inside of the namespace of the object being validated
+	ReferenceScopeNamespace = "namespace"
+)
+
+var (
+	referenceScopes = []string{ReferenceScopeCluster, ReferenceScopeNamespace}
+
+	apiVersionRegexp = regexp.MustCompile(`^([a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*/)?v[0-9]+((alpha|beta)[0-9]+)?$`)
+	kindRegexp       = regexp.MustCompile(`^[A-Z][A-Za-z0-9]*$`)
+)
+
+// ObjectReference requires the value of a label to refer to an existing
+// Kubernetes object, either by its name or by the value of one of its
+// labels:
+//
+//	{ "api_version": "v1", "kind": "Namespace" }
+//	{ "api_version": "example.com/v1", "kind": "Team", "label": "team-name" }
+//	{ "api_version": "v1", "kind": "Service", "scope": "namespace" }
+type ObjectReference struct {
+	APIVersion string `json:"api_version"`
+	Kind       string `json:"kind"`
+	// One of `referenceScopes`, defaults to ReferenceScopeCluster
+	Scope string `json:"scope,omitempty"`
+	// The label of the referenced object holding the value, the name of
+	// the object is used when this is not set
+	Label string `json:"label,omitempty"`
+}
+
+func (r ObjectReference) namespaced() bool {
+	return r.Scope == ReferenceScopeNamespace
+}
+
+// Describes the object referenced by the given value
+func (r ObjectReference) describe(namespace, value string) string {
+	referent := fmt.Sprintf("%s %s", r.Kind, value)
+	if r.Label != "" {
+		referent = fmt.Sprintf("%s with label %s=%s", r.Kind, r.Label, value)
+	}
+	if r.namespaced() {
+		referent += " in namespace " + namespace
+	}
+	return referent
+}
+
+// Checks whether the objects referenced by the labels exist. Each object is
+// looked up at most once per admission request
+type referenceLookup struct {
+	found map[string]bool
+}
+
+func newReferenceLookup() *referenceLookup {
+	return &referenceLookup{found: make(map[string]bool)}
+}
+
+// Returns true when the object referenced by the value exists. Objects
+// are listed using a selector, which tells a missing object apart from
+// a failed lookup
+func (l *referenceLookup) exists(ref ObjectReference, namespace, value string) (bool, error) {
+	if len(labelValueErrors(value)) > 0 || value == "" {
+		// not a valid name or label value, no object can match it
+		return false, nil
+	}
+	if ref.namespaced() && namespace == "" {
+		return false, nil
+	}
+
+	cacheKey := strings.Join([]string{ref.APIVersion, ref.Kind, ref.Label, namespace, value}, "/")
+	if found, cached := l.found[cacheKey]; cached {
+		return found, nil
+	}
+
+	var labelSelector, fieldSelector *string
+	if ref.Label != "" {
+		selector := ref.Label + "=" + value
+		labelSelector = &selector
+	} else {
+		selector := "metadata.name=" + value
+		fieldSelector = &selector
+	}
+
+	var response []byte
+	var err error
+	if ref.namespaced() {
+		response, err = kubernetes.ListResourcesByNamespace(&host, kubernetes.ListResourcesByNamespaceRequest{
+			APIVersion:    ref.APIVersion,
+			Kind:          ref.Kind,
+			Namespace:     namespace,
+			LabelSelector: labelSelector,
+			FieldSelector: fieldSelector,
+		})
+	} else {
+		response, err = kubernetes.ListResources(&host, kubernetes.ListAllResourcesRequest{
+			APIVersion:    ref.APIVersion,
+			Kind:          ref.Kind,
+			LabelSelector: labelSelector,
+			FieldSelector: fieldSelector,
+		})
+	}
+	if err != nil {
+		return false, fmt.Errorf("cannot look up %s objects: %w", ref.Kind, err)
+	}
+	if !gjson.ValidBytes(response) {
+		return false, fmt.Errorf("cannot look up %s objects: the host returned a malformed list", ref.Kind)
+	}
+
+	found := gjson.GetBytes(response, "items.#").Int() > 0
+	l.found[cacheKey] = found
+	return found, nil
+}
+
+// Checks the references, they need context-aware lookups
+func (s *Settings) validateReferenceConstraints() []string {
+	errors := []string{}
+	if len(s.ReferenceConstraints) == 0 || s.ContextAware {
+		return errors
+	}
+
+	labels := make([]string, 0, len(s.ReferenceConstraints))
+	for label := range s.ReferenceConstraints {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	errors = append(errors, fmt.Sprintf(
+		"constrained labels %s reference other objects, which requires context_aware to be enabled",
+		strings.Join(labels, ",")))
+	return errors
+}
+
+// Decodes the reference to the objects the value of a label points at
+func (d *settingsDecoder) objectReference(path string, raw json.RawMessage) (*ObjectReference, bool) {
+	ref := ObjectReference{}
+	errsBefore := len(d.errs)
+
+	d.object(path, raw, fieldDecoders{
+		"api_version": func(path string, raw json.RawMessage) {
+			ref.APIVersion, _ = d.apiVersion(path, raw)
+		},
+		"kind": func(path string, raw json.RawMessage) {
+			ref.Kind, _ = d.kind(path, raw)
+		},
+		"scope": func(path string, raw json.RawMessage) {
+			if scope, ok := d.string(path, raw); ok {
+				if !slices.Contains(referenceScopes, scope) {
+					d.fail(path, "must be one of: %s", strings.Join(referenceScopes, ", "))
+					return
+				}
+				ref.Scope = scope
+			}
+		},
+		"label": func(path string, raw json.RawMessage) {
+			if label, ok := d.string(path, raw); ok && d.labelKey(path, label) {
+				ref.Label = label
+			}
+		},
+	})
+	if len(d.errs) != errsBefore {
+		return nil, false
+	}
+
+	switch {
+	case ref.APIVersion == "":
+		d.fail(path, "missing required field api_version")
+	case ref.Kind == "":
+		d.fail(path, "missing required field kind")
+	}
+	return &ref, len(d.errs) == errsBefore
+}
+
+func (d *settingsDecoder) apiVersion(path string, raw json.RawMessage) (string, bool) {
+	apiVersion, ok := d.string(path, raw)
+	if !ok {
+		return "", false
+	}
+	if !apiVersionRegexp.MatchString(apiVersion) {
+		d.fail(path, "%q is not a valid API version, like v1 or example.com/v1", apiVersion)
+		return "", false
+	}
+	return apiVersion, true
+}
+
+func (d *settingsDecoder) kind(path string, raw json.RawMessage) (string, bool) {
+	kind, ok := d.string(path, raw)
+	if !ok {
+		return "", false
+	}
+	if !kindRegexp.MatchString(kind) {
+		d.fail(path, "%q is not a valid kind, like Service or Team", kind)
+		return "", false
+	}
+	return kind, true
+}
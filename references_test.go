@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+	kubewarden_testing "github.com/kubewarden/policy-sdk-go/testing"
+)
+
+// A policy host whose lookups always fail
+type unreachableCluster struct{}
+
+func (unreachableCluster) HostCall(binding, namespace, operation string, payload []byte) ([]byte, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestReferenceConstraints(t *testing.T) {
+	cases := []struct {
+		name             string
+		rule             string
+		objects          map[string]string
+		expectedAccepted bool
+		expectedMessage  string
+	}{
+		{
+			name: "object referenced by name",
+			rule: `{ "type": "constrained", "key": "app",
+				"references": { "api_version": "v1", "kind": "Service", "scope": "namespace" } }`,
+			objects: map[string]string{
+				objectKey("Service", "payments", "web"): `{"metadata": {"name": "web", "namespace": "payments"}}`,
+			},
+			expectedAccepted: true,
+		},
+		{
+			name: "object referenced by name inside of another namespace",
+			rule: `{ "type": "constrained", "key": "app",
+				"references": { "api_version": "v1", "kind": "Service", "scope": "namespace" } }`,
+			objects: map[string]string{
+				objectKey("Service", "default", "web"): `{"metadata": {"name": "web", "namespace": "default"}}`,
+			},
+			expectedMessage: "The following labels refer to objects that do not exist: app (Service web in namespace payments)",
+		},
+		{
+			name: "object referenced by label",
+			rule: `{ "type": "constrained", "key": "ownr",
+				"references": { "api_version": "example.com/v1", "kind": "Team", "label": "name" } }`,
+			objects: map[string]string{
+				objectKey("Team", "", "payments"): `{"metadata": {"name": "payments", "labels": {"name": "team-payments"}}}`,
+			},
+			expectedAccepted: true,
+		},
+		{
+			name: "missing object referenced by label",
+			rule: `{ "type": "constrained", "key": "ownr",
+				"references": { "api_version": "example.com/v1", "kind": "Team", "label": "name" } }`,
+			objects: map[string]string{
+				objectKey("Team", "", "checkout"): `{"metadata": {"name": "checkout", "labels": {"name": "team-checkout"}}}`,
+			},
+			expectedMessage: "The following labels refer to objects that do not exist: ownr (Team with label name=team-payments)",
+		},
+		{
+			name: "missing cluster-wide object",
+			rule: `{ "type": "constrained", "key": "environment",
+				"references": { "api_version": "v1", "kind": "Namespace" } }`,
+			objects: map[string]string{
+				objectKey("Namespace", "", "prod"): `{"metadata": {"name": "prod"}}`,
+			},
+			expectedMessage: "The following labels refer to objects that do not exist: environment (Namespace prdo)",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			useFakeCluster(t, &fakeCluster{objects: tc.objects})
+
+			settings, err := NewSettingsFromValidateSettingsPayload([]byte(`
+			{
+				"version": 2,
+				"context_aware": true,
+				"rules": [ ` + tc.rule + ` ]
+			}`))
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+			if valid, err := settings.Valid(); !valid {
+				t.Fatalf("Expected settings to be valid: %v", err)
+			}
+
+			payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+				"test_data/pod.json",
+				&settings)
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			responsePayload, err := validate(payload)
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			var response kubewarden_protocol.ValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			if response.Accepted != tc.expectedAccepted {
+				t.Fatalf("Unexpected response: %+v", response)
+			}
+			if tc.expectedAccepted {
+				return
+			}
+			if !strings.HasPrefix(*response.Message, tc.expectedMessage) {
+				t.Errorf("Unexpected rejection message: %s", *response.Message)
+			}
+		})
+	}
+}
+
+func TestReferenceConstraintsFailedLookup(t *testing.T) {
+	previous := host.Client
+	host.Client = unreachableCluster{}
+	t.Cleanup(func() { host.Client = previous })
+
+	settings, err := NewSettingsFromValidateSettingsPayload([]byte(`
+	{
+		"version": 2,
+		"context_aware": true,
+		"rules": [ { "type": "constrained", "key": "app",
+			"references": { "api_version": "v1", "kind": "Service", "scope": "namespace" } } ]
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+		"test_data/pod.json",
+		&settings)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	responsePayload, err := validate(payload)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	var response kubewarden_protocol.ValidationResponse
+	if err := json.Unmarshal(responsePayload, &response); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	if response.Accepted {
+		t.Fatal("Unexpected acceptance")
+	}
+	expected := "The constraints of the following labels cannot be checked: " +
+		"app (cannot look up Service objects: connection refused)"
+	if *response.Message != expected {
+		t.Errorf("Unexpected rejection message: %s", *response.Message)
+	}
+}
+
+func TestDetectNotValidObjectReferences(t *testing.T) {
+	cases := []struct {
+		name            string
+		references      string
+		expectedMessage string
+	}{
+		{
+			name:            "not valid API version",
+			references:      `{ "api_version": "example.com", "kind": "Team" }`,
+			expectedMessage: `/rules/0/references/api_version: "example.com" is not a valid API version, like v1 or example.com/v1`,
+		},
+		{
+			name:            "not valid kind",
+			references:      `{ "api_version": "v1", "kind": "services" }`,
+			expectedMessage: `/rules/0/references/kind: "services" is not a valid kind, like Service or Team`,
+		},
+		{
+			name:            "unknown scope",
+			references:      `{ "api_version": "v1", "kind": "Service", "scope": "namespaced" }`,
+			expectedMessage: "/rules/0/references/scope: must be one of: cluster, namespace",
+		},
+		{
+			name:            "missing kind",
+			references:      `{ "api_version": "v1" }`,
+			expectedMessage: "/rules/0/references: missing required field kind",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			responsePayload, err := validateSettings([]byte(`
+			{
+				"version": 2,
+				"context_aware": true,
+				"rules": [ { "type": "constrained", "key": "team", "references": ` + tc.references + ` } ]
+			}`))
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			var response kubewarden_protocol.SettingsValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			if response.Valid {
+				t.Fatal("Expected settings to not be valid")
+			}
+			if !strings.Contains(*response.Message, tc.expectedMessage) {
+				t.Errorf("Unexpected message: %s", *response.Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	mapset "github.com/deckarep/golang-set/v2"
+)
+
+// Versions of the settings schema
+const (
+	// Flat `denied_labels`, `mandatory_labels` and `constrained_labels`
+	// fields. This is the version assumed when `version` is not set
+	SettingsVersion1 = 1
+	// Structured rules, listed inside of the `rules` field
+	SettingsVersion2 = 2
+
+	CurrentSettingsVersion = SettingsVersion2
+)
+
+// The type of a rule of the v2 schema
+type RuleType string
+
+const (
+	DeniedRule      RuleType = "denied"
+	MandatoryRule   RuleType = "mandatory"
+	ConstrainedRule RuleType = "constrained"
+)
+
+// The category of rules each rule type belongs to
+var ruleTypeCategories = map[RuleType]RuleCategory{
+	DeniedRule:      DeniedLabelsRule,
+	MandatoryRule:   MandatoryLabelsRule,
+	ConstrainedRule: ConstrainedLabelsRule,
+}
+
+// A rule of the v2 schema:
+//
+//	{ "type": "denied", "key": "foo" }
+//	{ "type": "mandatory", "key": "owner", "message": "..." }
+//	{ "type": "constrained", "key": "owner", "pattern": "^team-" }
+//	{ "type": "constrained", "key": "env", "allowed_values": ["prod", "dev"] }
+//	{ "type": "constrained", "key": "cost-center", "config_map": { ... } }
+//	{ "type": "constrained", "key": "team", "references": { ... } }
+//	{ "type": "constrained", "key": "app-id", "unique": { ... } }
+type Rule struct {
+	Type          RuleType           `json:"type"`
+	Key           string             `json:"key"`
+	Pattern       *RegularExpression `json:"pattern,omitempty"`
+	AllowedValues []string           `json:"allowed_values,omitempty"`
+	// The ConfigMap the constraint is read from, see ConfigMapSource
+	ConfigMap *ConfigMapSource `json:"config_map,omitempty"`
+	// The objects the value must refer to, see ObjectReference
+	References *ObjectReference `json:"references,omitempty"`
+	// The objects the value must be unique among, see UniqueConstraint
+	Unique *UniqueConstraint `json:"unique,omitempty"`
+	RuleOptions
+}
+
+// MarshalJSON omits the pattern of the constraints defined using a list
+// of allowed values, the pattern is generated from them
+func (r Rule) MarshalJSON() ([]byte, error) {
+	type plainRule Rule
+	plain := plainRule(r)
+	if plain.AllowedValues != nil {
+		plain.Pattern = nil
+	}
+	return json.Marshal(plain)
+}
+
+func (r Rule) ref() RuleRef {
+	return RuleRef{ruleTypeCategories[r.Type], r.Key}
+}
+
+// The rules of a v1 document, as written by the user
+type settingsV1Rules struct {
+	DeniedLabels      []labelRule
+	MandatoryLabels   []labelRule
+	ConstrainedLabels map[string]labelConstraint
+	// The v1 fields found inside of the document, in order
+	Fields []string
+}
+
+// Converts the rules of a v1 document into the ones of the v2 schema
+func convertV1Rules(v1 settingsV1Rules) []Rule {
+	rules := []Rule{}
+
+	for _, rule := range v1.DeniedLabels {
+		rules = append(rules, Rule{Type: DeniedRule, Key: rule.Key, RuleOptions: rule.RuleOptions})
+	}
+	for _, rule := range v1.MandatoryLabels {
+		rules = append(rules, Rule{Type: MandatoryRule, Key: rule.Key, RuleOptions: rule.RuleOptions})
+	}
+
+	labels := make([]string, 0, len(v1.ConstrainedLabels))
+	for label := range v1.ConstrainedLabels {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		constraint := v1.ConstrainedLabels[label]
+		rules = append(rules, Rule{
+			Type:          ConstrainedRule,
+			Key:           label,
+			Pattern:       constraint.Pattern,
+			AllowedValues: constraint.AllowedValues,
+			RuleOptions:   constraint.RuleOptions,
+		})
+	}
+
+	return rules
+}
+
+// Describes how to replace the deprecated fields of a v1 document
+func (v1 settingsV1Rules) deprecations() []string {
+	replacements := map[string]RuleType{
+		string(DeniedLabelsRule):      DeniedRule,
+		string(MandatoryLabelsRule):   MandatoryRule,
+		string(ConstrainedLabelsRule): ConstrainedRule,
+	}
+
+	deprecations := []string{}
+	for _, field := range v1.Fields {
+		deprecations = append(deprecations, fmt.Sprintf(
+			"%s is deprecated, set version to %d and use rules of type %s instead",
+			field, CurrentSettingsVersion, replacements[field]))
+	}
+	return deprecations
+}
+
+// Adds a rule of the v2 schema to the settings
+func (s *Settings) addRule(rule Rule) {
+	if s.DeniedLabels == nil {
+		s.DeniedLabels = mapset.NewThreadUnsafeSet[string]()
+	}
+	if s.MandatoryLabels == nil {
+		s.MandatoryLabels = mapset.NewThreadUnsafeSet[string]()
+	}
+
+	switch rule.Type {
+	case DeniedRule:
+		s.DeniedLabels.Add(rule.Key)
+	case MandatoryRule:
+		s.MandatoryLabels.Add(rule.Key)
+	case ConstrainedRule:
+		if rule.ConfigMap != nil {
+			if s.ConfigMapConstraints == nil {
+				s.ConfigMapConstraints = make(map[string]ConfigMapSource)
+			}
+			s.ConfigMapConstraints[rule.Key] = *rule.ConfigMap
+			break
+		}
+		if rule.References != nil {
+			if s.ReferenceConstraints == nil {
+				s.ReferenceConstraints = make(map[string]ObjectReference)
+			}
+			s.ReferenceConstraints[rule.Key] = *rule.References
+			break
+		}
+		if rule.Unique != nil {
+			if s.UniqueConstraints == nil {
+				s.UniqueConstraints = make(map[string]UniqueConstraint)
+			}
+			s.UniqueConstraints[rule.Key] = *rule.Unique
+			break
+		}
+		if s.ConstrainedLabels == nil {
+			s.ConstrainedLabels = make(map[string]*RegularExpression)
+		}
+		s.ConstrainedLabels[rule.Key] = rule.Pattern
+		if rule.AllowedValues != nil {
+			if s.AllowedValues == nil {
+				s.AllowedValues = make(map[string][]string)
+			}
+			s.AllowedValues[rule.Key] = rule.AllowedValues
+		}
+	}
+	s.setRuleOptions(rule.ref(), rule.RuleOptions)
+}
+
+func (s *Settings) addRules(rules []Rule) {
+	for _, rule := range rules {
+		s.addRule(rule)
+	}
+}
+
+// Rules returns the rules of the settings using the v2 schema. Rules are
+// sorted by type and key
+func (s *Settings) Rules() []Rule {
+	rules := []Rule{}
+
+	labelRules := func(ruleType RuleType, labels mapset.Set[string]) {
+		if labels == nil {
+			return
+		}
+		keys := labels.ToSlice()
+		sort.Strings(keys)
+		for _, key := range keys {
+			rules = append(rules, Rule{
+				Type:        ruleType,
+				Key:         key,
+				RuleOptions: s.RuleOptions(RuleRef{ruleTypeCategories[ruleType], key}),
+			})
+		}
+	}
+	labelRules(DeniedRule, s.DeniedLabels)
+	labelRules(MandatoryRule, s.MandatoryLabels)
+
+	labels := make([]string, 0,
+		len(s.ConstrainedLabels)+len(s.ConfigMapConstraints)+len(s.ReferenceConstraints)+len(s.UniqueConstraints))
+	for label := range s.ConstrainedLabels {
+		labels = append(labels, label)
+	}
+	for label := range s.ConfigMapConstraints {
+		labels = append(labels, label)
+	}
+	for label := range s.ReferenceConstraints {
+		labels = append(labels, label)
+	}
+	for label := range s.UniqueConstraints {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		rule := Rule{
+			Type:          ConstrainedRule,
+			Key:           label,
+			Pattern:       s.ConstrainedLabels[label],
+			AllowedValues: s.AllowedValues[label],
+			RuleOptions:   s.RuleOptions(RuleRef{ConstrainedLabelsRule, label}),
+		}
+		if source, found := s.ConfigMapConstraints[label]; found {
+			rule.ConfigMap = &source
+		}
+		if ref, found := s.ReferenceConstraints[label]; found {
+			rule.References = &ref
+		}
+		if unique, found := s.UniqueConstraints[label]; found {
+			rule.Unique = &unique
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
+
+// MarshalJSON always produces a document that follows the latest
+// version of the settings schema
+func (s Settings) MarshalJSON() ([]byte, error) {
+	rawSettings := struct {
+		Version          int                     `json:"version"`
+		Rules            []Rule                  `json:"rules"`
+		Codes            map[RuleCategory]uint16 `json:"codes,omitempty"`
+		RejectNearMiss   bool                    `json:"reject_near_miss_labels,omitempty"`
+		CheckLabelSyntax bool                    `json:"check_label_syntax,omitempty"`
+		Analysis         string                  `json:"analysis,omitempty"`
+		Limits           *Limits                 `json:"limits,omitempty"`
+		ContextAware     bool                    `json:"context_aware,omitempty"`
+		Namespace        *NamespaceRules         `json:"namespace,omitempty"`
+		Owners           *OwnerRules             `json:"owners,omitempty"`
+		LabelOwners      []LabelOwner            `json:"label_owners,omitempty"`
+		Conditions       []LabelCondition        `json:"conditions,omitempty"`
+		AllowedLabels    *AllowedLabels          `json:"allowed_labels,omitempty"`
+		StickyLabels     []string                `json:"sticky_labels,omitempty"`
+		TenantPolicies   *ObjectKind             `json:"tenant_policies,omitempty"`
+		Profiles         map[string]Profile      `json:"profiles,omitempty"`
+		ProfileSelectors []ProfileSelector       `json:"profile_selectors,omitempty"`
+	}{
+		Version:          CurrentSettingsVersion,
+		Rules:            s.Rules(),
+		Codes:            s.Codes,
+		RejectNearMiss:   s.RejectNearMissLabels,
+		CheckLabelSyntax: s.CheckLabelSyntax,
+		Analysis:         s.Analysis,
+		ContextAware:     s.ContextAware,
+		LabelOwners:      s.LabelOwners,
+		Conditions:       s.Conditions,
+		AllowedLabels:    s.AllowedLabels,
+		StickyLabels:     s.StickyLabels,
+		Profiles:         s.Profiles,
+		ProfileSelectors: s.ProfileSelectors,
+	}
+
+	if !s.Limits.isZero() {
+		rawSettings.Limits = &s.Limits
+	}
+	if !s.Namespace.isZero() {
+		rawSettings.Namespace = &s.Namespace
+	}
+	if !s.Owners.isZero() {
+		rawSettings.Owners = &s.Owners
+	}
+	rawSettings.TenantPolicies = s.TenantPolicies
+
+	return json.Marshal(rawSettings)
+}
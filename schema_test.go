@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+)
+
+func TestConvertV1SettingsToV2(t *testing.T) {
+	v1, err := NewSettingsFromValidateSettingsPayload([]byte(`
+	{
+		"denied_labels": [ "foo", { "key": "bar", "id": "LBL-1" } ],
+		"mandatory_labels": [ "owner" ],
+		"constrained_labels": {
+			"owner": "^team-",
+			"env": { "allowed_values": [ "prod", "dev" ], "message": "use {{allowed_values}}" }
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	v2, err := NewSettingsFromValidateSettingsPayload([]byte(`
+	{
+		"version": 2,
+		"rules": [
+			{ "type": "denied", "key": "foo" },
+			{ "type": "denied", "key": "bar", "id": "LBL-1" },
+			{ "type": "mandatory", "key": "owner" },
+			{ "type": "constrained", "key": "owner", "pattern": "^team-" },
+			{ "type": "constrained", "key": "env", "allowed_values": [ "prod", "dev" ], "message": "use {{allowed_values}}" }
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	if v1.Version != SettingsVersion1 || v2.Version != SettingsVersion2 {
+		t.Errorf("Unexpected versions: %d, %d", v1.Version, v2.Version)
+	}
+
+	v1JSON, err := json.Marshal(v1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	v2JSON, err := json.Marshal(v2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if string(v1JSON) != string(v2JSON) {
+		t.Errorf("Converted settings differ:\n%s\n%s", v1JSON, v2JSON)
+	}
+
+	expected := `{"version":2,"rules":[` +
+		`{"type":"denied","key":"bar","id":"LBL-1"},` +
+		`{"type":"denied","key":"foo"},` +
+		`{"type":"mandatory","key":"owner"},` +
+		`{"type":"constrained","key":"env","allowed_values":["prod","dev"],"message":"use {{allowed_values}}"},` +
+		`{"type":"constrained","key":"owner","pattern":"^team-"}]}`
+	if string(v2JSON) != expected {
+		t.Errorf("Unexpected serialization: %s", v2JSON)
+	}
+}
+
+func TestV1SettingsReportDeprecations(t *testing.T) {
+	request := `
+	{
+		"denied_labels": [ "foo" ],
+		"constrained_labels": { "owner": "^team-" }
+	}
+	`
+	responsePayload, err := validateSettings([]byte(request))
+	if err != nil {
+		t.Errorf("Unexpected error %+v", err)
+	}
+
+	var response kubewarden_protocol.SettingsValidationResponse
+	if err := json.Unmarshal(responsePayload, &response); err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+
+	if !response.Valid {
+		t.Errorf("Expected settings to be valid: %s", *response.Message)
+	}
+
+	expectedMessage := "Provided settings are valid, but: " +
+		"denied_labels is deprecated, set version to 2 and use rules of type denied instead; " +
+		"constrained_labels is deprecated, set version to 2 and use rules of type constrained instead"
+	if response.Message == nil || *response.Message != expectedMessage {
+		t.Errorf("Unexpected validation message: %v", response.Message)
+	}
+}
+
+func TestDetectNotValidV2Settings(t *testing.T) {
+	cases := []struct {
+		name            string
+		settings        string
+		expectedMessage string
+	}{
+		{
+			name:            "rules require version 2",
+			settings:        `{"rules": [{"type": "denied", "key": "foo"}]}`,
+			expectedMessage: "/rules: requires version 2",
+		},
+		{
+			name:            "v1 fields are not supported by version 2",
+			settings:        `{"version": 2, "denied_labels": ["foo"]}`,
+			expectedMessage: "/denied_labels: not supported by version 2, use rules instead",
+		},
+		{
+			name:            "unsupported version",
+			settings:        `{"version": 3}`,
+			expectedMessage: "/version: unsupported version 3, must be either 1 or 2",
+		},
+		{
+			name: "invalid rules",
+			settings: `{
+				"version": 2,
+				"rules": [
+					{"type": "forbidden", "key": "foo"},
+					{"type": "denied", "key": "foo", "pattern": "bar"},
+					{"type": "constrained", "key": "owner"},
+					{"key": "owner"},
+					{"type": "mandatory", "key": "owner"},
+					{"type": "mandatory", "key": "owner"}
+				]
+			}`,
+			expectedMessage: "/rules/0/type: unknown rule type \"forbidden\", must be one of: denied, mandatory, constrained; " +
+				"/rules/1: pattern, allowed_values, config_map, references and unique can be set only on rules of type constrained; " +
+				"/rules/2: needs either a pattern or allowed_values; " +
+				"/rules/3: missing required field type; " +
+				"/rules/5: duplicated mandatory rule for label owner",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			responsePayload, err := validateSettings([]byte(tc.settings))
+			if err != nil {
+				t.Errorf("Unexpected error %+v", err)
+			}
+
+			var response kubewarden_protocol.SettingsValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Errorf("Unexpected error: %+v", err)
+			}
+
+			if response.Valid {
+				t.Error("Expected settings to not be valid")
+			}
+
+			expectedMessage := "Provided settings are not valid: " + tc.expectedMessage
+			if *response.Message != expectedMessage {
+				t.Errorf("Unexpected validation error message: %s", *response.Message)
+			}
+		})
+	}
+}
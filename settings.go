@@ -3,12 +3,16 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"regexp"
+	"slices"
+	"sort"
 	"strings"
 
 	mapset "github.com/deckarep/golang-set/v2"
 	"github.com/kubewarden/gjson"
 	kubewarden "github.com/kubewarden/policy-sdk-go"
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
 )
 
 // A wrapper around the standard regexp.Regexp struct
@@ -47,10 +51,252 @@ func (r *RegularExpression) MarshalText() ([]byte, error) {
 	return nil, nil
 }
 
+// The categories of rules that can be defined inside of the settings
+type RuleCategory string
+
+const (
+	DeniedLabelsRule      RuleCategory = "denied_labels"
+	MandatoryLabelsRule   RuleCategory = "mandatory_labels"
+	ConstrainedLabelsRule RuleCategory = "constrained_labels"
+	NearMissLabelsRule    RuleCategory = "near_miss_labels"
+	LabelSyntaxRule       RuleCategory = "label_syntax"
+	NamespaceMatchRule    RuleCategory = "namespace_match"
+	OwnerMatchRule        RuleCategory = "owner_match"
+	LabelOwnersRule       RuleCategory = "label_owners"
+	StickyLabelsRule      RuleCategory = "sticky_labels"
+	AllowedLabelsRule     RuleCategory = "allowed_labels"
+	ConditionsRule        RuleCategory = "conditions"
+)
+
+// Identifies a single rule defined inside of the settings
+type RuleRef struct {
+	Category RuleCategory
+	Label    string
+}
+
+// Optional attributes of a rule. These can be set only when the
+// rule is written using its long form
+type RuleOptions struct {
+	Message *MessageTemplate `json:"message,omitempty"`
+	// Unique identifier of the rule
+	ID string `json:"id,omitempty"`
+	// One of the values of `ruleSeverities`
+	Severity string `json:"severity,omitempty"`
+	// Link to the documentation of the rule
+	DocsURL string `json:"docs_url,omitempty"`
+	// The compliance controls enforced by the rule
+	Controls []string `json:"controls,omitempty"`
+	// The code of the rejection response, takes precedence over the
+	// code set for the whole category of rules
+	Code uint16 `json:"code,omitempty"`
+}
+
+var ruleSeverities = []string{"low", "medium", "high", "critical"}
+
+func (o RuleOptions) isZero() bool {
+	return o.Message == nil && o.Code == 0 && !o.hasMetadata()
+}
+
+func (o RuleOptions) hasMetadata() bool {
+	return o.ID != "" || o.Severity != "" || o.DocsURL != "" || len(o.Controls) > 0
+}
+
+// Returns a human readable summary of the rule metadata, like:
+// "[id: LBL-001, severity: high, controls: SOC2-CC6.1, docs: https://...]"
+func (o RuleOptions) metadataSummary() string {
+	details := []string{}
+	if o.ID != "" {
+		details = append(details, "id: "+o.ID)
+	}
+	if o.Severity != "" {
+		details = append(details, "severity: "+o.Severity)
+	}
+	if len(o.Controls) > 0 {
+		details = append(details, "controls: "+strings.Join(o.Controls, ", "))
+	}
+	if o.DocsURL != "" {
+		details = append(details, "docs: "+o.DocsURL)
+	}
+	return "[" + strings.Join(details, ", ") + "]"
+}
+
+// Checks the metadata of the rule, returns a list of problems
+func (o RuleOptions) validate(ref RuleRef) []string {
+	errors := []string{}
+
+	if o.Severity != "" && !slices.Contains(ruleSeverities, o.Severity) {
+		errors = append(errors, fmt.Sprintf(
+			"%s rule %s has invalid severity %q, must be one of: %s",
+			ref.Category, ref.Label, o.Severity, strings.Join(ruleSeverities, ", ")))
+	}
+
+	if o.Code != 0 && !validRejectionCode(o.Code) {
+		errors = append(errors, fmt.Sprintf(
+			"%s rule %s has invalid code %d, must be between %d and %d",
+			ref.Category, ref.Label, o.Code, minRejectionCode, maxRejectionCode))
+	}
+
+	if o.DocsURL != "" {
+		u, err := url.Parse(o.DocsURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			errors = append(errors, fmt.Sprintf(
+				"%s rule %s has invalid docs_url %q, must be an absolute URL",
+				ref.Category, ref.Label, o.DocsURL))
+		}
+	}
+
+	return errors
+}
+
+// Key of the `codes` setting holding the code used when no other
+// code applies
+const DefaultRejectionCode RuleCategory = "default"
+
+// Rejection codes must be valid HTTP client or server error codes
+const (
+	minRejectionCode = 400
+	maxRejectionCode = 599
+)
+
+// When the rules of multiple categories are violated, the rejection
+// code is taken from the first category of this list
+var ruleCategoriesPrecedence = []RuleCategory{
+	LabelSyntaxRule,
+	LabelOwnersRule,
+	DeniedLabelsRule,
+	AllowedLabelsRule,
+	StickyLabelsRule,
+	ConstrainedLabelsRule,
+	NamespaceMatchRule,
+	OwnerMatchRule,
+	ConditionsRule,
+	MandatoryLabelsRule,
+	NearMissLabelsRule,
+}
+
+func validRejectionCode(code uint16) bool {
+	return code >= minRejectionCode && code <= maxRejectionCode
+}
+
 type Settings struct {
 	DeniedLabels      mapset.Set[string]            `json:"denied_labels"`
 	MandatoryLabels   mapset.Set[string]            `json:"mandatory_labels"`
 	ConstrainedLabels map[string]*RegularExpression `json:"constrained_labels"`
+	// The rejection codes of each category of rules, plus the
+	// `default` one
+	Codes map[RuleCategory]uint16 `json:"codes,omitempty"`
+	// Reject the labels that look like typos of the mandatory or
+	// constrained ones
+	RejectNearMissLabels bool `json:"reject_near_miss_labels,omitempty"`
+	// Reject the objects whose labels do not follow the Kubernetes
+	// syntax rules. Useful for label maps embedded inside of custom
+	// resources, which are not checked by the API server
+	CheckLabelSyntax bool `json:"check_label_syntax,omitempty"`
+	// Strictness of the settings analysis, one of `analysisLevels`.
+	// An empty value is equivalent to `AnalysisDefault`
+	Analysis string `json:"analysis,omitempty"`
+	// The values accepted by the constrained labels that have been
+	// defined using `allowed_values` instead of a regular expression
+	AllowedValues map[string][]string `json:"-"`
+	// The constrained labels whose constraint is read from a ConfigMap
+	ConfigMapConstraints map[string]ConfigMapSource `json:"-"`
+	// The constrained labels whose value must refer to existing objects
+	ReferenceConstraints map[string]ObjectReference `json:"-"`
+	// The constrained labels whose value must be unique among other objects
+	UniqueConstraints map[string]UniqueConstraint `json:"-"`
+	// The options of the rules written using their long form
+	Options map[RuleRef]RuleOptions `json:"-"`
+	// The schema version of the document the settings have been
+	// decoded from
+	Version int `json:"-"`
+	// The deprecated fields used by the document the settings have
+	// been decoded from
+	Deprecations []string `json:"-"`
+	// The limits enforced on the settings
+	Limits Limits `json:"-"`
+	// Allow the policy to look up Kubernetes objects through the
+	// policy host
+	ContextAware bool `json:"-"`
+	// Relate the labels of the objects to the ones of their Namespace
+	Namespace NamespaceRules `json:"-"`
+	// Relate the labels of the objects to the ones of their owners
+	Owners OwnerRules `json:"-"`
+	// Restrict the users allowed to set, change or remove some labels
+	LabelOwners []LabelOwner `json:"-"`
+	// The requirements applied to the objects whose labels satisfy
+	// a condition
+	Conditions []LabelCondition `json:"-"`
+	// Reject the labels that are not explicitly allowed, nil when any
+	// label is allowed
+	AllowedLabels *AllowedLabels `json:"-"`
+	// The labels, or key patterns, that cannot be removed from the
+	// objects once they are set
+	StickyLabels []string `json:"-"`
+	// The kind of the custom resources holding the label rules of each
+	// namespace. Their `spec` is a settings document, whose rules are
+	// enforced on top of the ones of the settings
+	TenantPolicies *ObjectKind `json:"-"`
+	// The named profiles, see Profile
+	Profiles map[string]Profile `json:"-"`
+	// Choose the profile used by each admission request, the first
+	// matching selector wins
+	ProfileSelectors []ProfileSelector `json:"-"`
+	// The settings produced by each profile
+	effectiveProfiles map[string]*Settings
+	// Matches the keys of the denied rules, which can be patterns
+	deniedKeys *keyMatcher
+	// Matches the keys of the sticky labels, nil when there are none
+	stickyKeys *keyMatcher
+	// Matches the allowed keys, nil when any label is allowed
+	allowedKeys *keyMatcher
+}
+
+// A denied or mandatory label of a v1 document. The rule can be written
+// either as a plain string or, using its long form, as an object:
+//
+//	{ "key": "owner", "message": "..." }
+type labelRule struct {
+	Key string `json:"key"`
+	RuleOptions
+}
+
+// The constraint of a label of a v1 document. The constraint can be
+// written either as a plain string holding a regular expression or,
+// using its long form, as an object:
+//
+//	{ "pattern": "^team-", "message": "..." }
+//	{ "allowed_values": ["prod", "staging"], "message": "..." }
+type labelConstraint struct {
+	Pattern       *RegularExpression `json:"pattern,omitempty"`
+	AllowedValues []string           `json:"allowed_values,omitempty"`
+	RuleOptions
+}
+
+// Builds a regular expression that matches only the given values
+func allowedValuesRegularExpression(values []string) *RegularExpression {
+	quoted := make([]string, 0, len(values))
+	for _, value := range values {
+		quoted = append(quoted, regexp.QuoteMeta(value))
+	}
+	return &RegularExpression{
+		regexp.MustCompile(fmt.Sprintf("^(?:%s)$", strings.Join(quoted, "|"))),
+	}
+}
+
+// Returns the options of the given rule, the zero value is
+// returned when the rule has been written using its short form
+func (s *Settings) RuleOptions(ref RuleRef) RuleOptions {
+	return s.Options[ref]
+}
+
+func (s *Settings) setRuleOptions(ref RuleRef, options RuleOptions) {
+	if options.isZero() {
+		return
+	}
+	if s.Options == nil {
+		s.Options = make(map[RuleRef]RuleOptions)
+	}
+	s.Options[ref] = options
 }
 
 // Builds a new Settings instance starting from a validation
@@ -64,16 +310,13 @@ type Settings struct {
 //	      "constrained_labels": { ... }
 //	   }
 //	}
+//
+// The settings are decoded only the first time they are seen, see
+// settingsCache
 func NewSettingsFromValidationReq(payload []byte) (Settings, error) {
 	settingsJson := gjson.GetBytes(payload, "settings")
 
-	settings := Settings{}
-	err := json.Unmarshal([]byte(settingsJson.Raw), &settings)
-	if err != nil {
-		return Settings{}, err
-	}
-
-	return settings, nil
+	return parsedSettings.get([]byte(settingsJson.Raw))
 }
 
 // Builds a new Settings instance starting from a Settings
@@ -94,15 +337,47 @@ func NewSettingsFromValidateSettingsPayload(payload []byte) (Settings, error) {
 }
 
 func (s *Settings) Valid() (bool, error) {
+	errors := s.validationErrors(nil)
+	errors = append(errors, s.validateProfiles(errors)...)
+
+	if len(errors) > 0 {
+		return false, fmt.Errorf("%s", strings.Join(errors, "; "))
+	}
+	return true, nil
+}
+
+// Returns the labels that are both constrained and denied
+func (s *Settings) constrainedAndDenied() mapset.Set[string] {
 	constrainedLabels := mapset.NewThreadUnsafeSet[string]()
 
 	for label := range s.ConstrainedLabels {
 		constrainedLabels.Add(label)
 	}
+	for label := range s.ConfigMapConstraints {
+		constrainedLabels.Add(label)
+	}
+	for label := range s.ReferenceConstraints {
+		constrainedLabels.Add(label)
+	}
+	for label := range s.UniqueConstraints {
+		constrainedLabels.Add(label)
+	}
+	return s.deniedAmong(constrainedLabels)
+}
 
+// Returns the problems of the settings, leaving out the ones of the
+// effective settings of their profiles. When `base` is set, the label
+// conflicts that are already part of it are left out too
+func (s *Settings) validationErrors(base *Settings) []string {
 	errors := []string{}
 
-	constrainedAndDenied := constrainedLabels.Intersect(s.DeniedLabels)
+	constrainedAndDenied := s.constrainedAndDenied()
+	mandatoryAndDenied := s.deniedAmong(s.MandatoryLabels)
+	if base != nil {
+		constrainedAndDenied = constrainedAndDenied.Difference(base.constrainedAndDenied())
+		mandatoryAndDenied = mandatoryAndDenied.Difference(base.deniedAmong(base.MandatoryLabels))
+	}
+
 	if constrainedAndDenied.Cardinality() != 0 {
 		violations := constrainedAndDenied.ToSlice()
 		errors = append(
@@ -114,7 +389,6 @@ func (s *Settings) Valid() (bool, error) {
 		)
 	}
 
-	mandatoryAndDenied := s.MandatoryLabels.Intersect(s.DeniedLabels)
 	if mandatoryAndDenied.Cardinality() != 0 {
 		violations := mandatoryAndDenied.ToSlice()
 		errors = append(
@@ -126,45 +400,172 @@ func (s *Settings) Valid() (bool, error) {
 		)
 	}
 
-	if len(errors) > 0 {
-		return false, fmt.Errorf("%s", strings.Join(errors, "; "))
+	if s.deniedKeys != nil && s.deniedKeys.programSize > s.Limits.maxKeyPatternsProgramSize() {
+		errors = append(errors, fmt.Sprintf(
+			"the denied key patterns are too complex: they compile to %d instructions, the limit is %d",
+			s.deniedKeys.programSize, s.Limits.maxKeyPatternsProgramSize()))
 	}
-	return true, nil
+
+	errors = append(errors, s.validateLimits()...)
+	errors = append(errors, s.validateCodes()...)
+	errors = append(errors, s.validateRuleOptions()...)
+	errors = append(errors, s.validateProfileSelectors()...)
+	errors = append(errors, s.validateNamespaceRules()...)
+	errors = append(errors, s.validateOwnerRules()...)
+	errors = append(errors, s.validateTenantPolicies()...)
+	errors = append(errors, s.validateLabelOwners()...)
+	errors = append(errors, s.validateStickyLabels()...)
+	errors = append(errors, s.validateAllowedLabels()...)
+	errors = append(errors, s.validateConditions()...)
+	errors = append(errors, s.validateConfigMapConstraints()...)
+	errors = append(errors, s.validateReferenceConstraints()...)
+	errors = append(errors, s.validateUniqueConstraints()...)
+
+	if s.Analysis != "" && !slices.Contains(analysisLevels, s.Analysis) {
+		errors = append(errors, fmt.Sprintf(
+			"analysis must be one of: %s", strings.Join(analysisLevels, ", ")))
+	} else {
+		errors = append(errors, s.analysisErrors()...)
+	}
+	return errors
 }
 
-func (s *Settings) UnmarshalJSON(data []byte) error {
-	// This is needed becaus golang-set v2.3.0 has a bug that prevents
-	// the correct unmarshalling of ThreadUnsafeSet types.
-	rawSettings := struct {
-		DeniedLabels      []string                      `json:"denied_labels"`
-		MandatoryLabels   []string                      `json:"mandatory_labels"`
-		ConstrainedLabels map[string]*RegularExpression `json:"constrained_labels"`
-	}{}
-
-	err := json.Unmarshal(data, &rawSettings)
-	if err != nil {
-		return err
+func (s *Settings) validateCodes() []string {
+	errors := []string{}
+
+	categories := make([]string, 0, len(s.Codes))
+	for category := range s.Codes {
+		categories = append(categories, string(category))
+	}
+	sort.Strings(categories)
+
+	for _, category := range categories {
+		code := s.Codes[RuleCategory(category)]
+		if RuleCategory(category) != DefaultRejectionCode &&
+			!slices.Contains(ruleCategoriesPrecedence, RuleCategory(category)) {
+			errors = append(errors, fmt.Sprintf("unknown category %q inside of codes", category))
+			continue
+		}
+		if !validRejectionCode(code) {
+			errors = append(errors, fmt.Sprintf(
+				"code %d of %s is not valid, must be between %d and %d",
+				code, category, minRejectionCode, maxRejectionCode))
+		}
+	}
+
+	return errors
+}
+
+// Returns the code of the rejection caused by the given violations.
+// The categories are inspected following `ruleCategoriesPrecedence`:
+// the code of the first violated rule that has one is used, otherwise the
+// one of its category. The `default` code is used as a fallback.
+func (s *Settings) rejectionCode(violations map[RuleCategory][]string) uint16 {
+	for _, category := range ruleCategoriesPrecedence {
+		labels := violations[category]
+		if len(labels) == 0 {
+			continue
+		}
+		for _, label := range labels {
+			if code := s.RuleOptions(s.violatedRule(category, label)).Code; code != 0 {
+				return code
+			}
+		}
+		if code := s.Codes[category]; code != 0 {
+			return code
+		}
 	}
 
-	s.DeniedLabels = mapset.NewThreadUnsafeSet[string](rawSettings.DeniedLabels...)
-	s.MandatoryLabels = mapset.NewThreadUnsafeSet[string](rawSettings.MandatoryLabels...)
-	s.ConstrainedLabels = rawSettings.ConstrainedLabels
+	return s.Codes[DefaultRejectionCode]
+}
+
+// Checks the options of all the rules and ensures rule IDs are unique
+func (s *Settings) validateRuleOptions() []string {
+	errors := []string{}
+
+	refs := make([]RuleRef, 0, len(s.Options))
+	for ref := range s.Options {
+		refs = append(refs, ref)
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Category != refs[j].Category {
+			return refs[i].Category < refs[j].Category
+		}
+		return refs[i].Label < refs[j].Label
+	})
 
+	ruleIDs := make(map[string]RuleRef)
+	for _, ref := range refs {
+		options := s.Options[ref]
+		errors = append(errors, options.validate(ref)...)
+
+		if options.ID == "" {
+			continue
+		}
+		if other, found := ruleIDs[options.ID]; found {
+			errors = append(errors, fmt.Sprintf(
+				"rule ID %s is used by both %s rule %s and %s rule %s",
+				options.ID, other.Category, other.Label, ref.Category, ref.Label))
+			continue
+		}
+		ruleIDs[options.ID] = ref
+	}
+
+	return errors
+}
+
+// UnmarshalJSON decodes the settings in a strict way, see settingsDecoder.
+// All the problems found are returned together as a fieldErrors error
+func (s *Settings) UnmarshalJSON(data []byte) error {
+	decoder := settingsDecoder{}
+	settings := decoder.settings(data)
+	if len(decoder.errs) > 0 {
+		return decoder.errs
+	}
+
+	*s = settings
 	return nil
 }
 
 func validateSettings(payload []byte) ([]byte, error) {
+	sizeLimit := Limits{
+		MaxSettingsSize: int(gjson.GetBytes(payload, "limits.max_settings_size").Int()),
+	}
+	if err := checkSettingsSize(payload, sizeLimit); err != nil {
+		return kubewarden.RejectSettings(
+			kubewarden.Message(fmt.Sprintf("Provided settings are not valid: %v", err)))
+	}
+
 	settings, err := NewSettingsFromValidateSettingsPayload(payload)
 	if err != nil {
-		// this happens when one of the user-defined regular expressions are invalid
+		// this happens when the settings cannot be decoded, for example when
+		// they have unknown fields or invalid regular expressions
 		return kubewarden.RejectSettings(
 			kubewarden.Message(fmt.Sprintf("Provided settings are not valid: %v", err)))
 	}
 
 	valid, err := settings.Valid()
 	if valid {
-		return kubewarden.AcceptSettings()
+		settings.logEffectiveSettings()
+		return acceptSettingsWithWarnings(settings.Warnings())
 	}
 	return kubewarden.RejectSettings(
 		kubewarden.Message(fmt.Sprintf("Provided settings are not valid: %v", err)))
 }
+
+// Accepts the settings, reporting the given warnings to the user
+func acceptSettingsWithWarnings(warnings []string) ([]byte, error) {
+	if len(warnings) == 0 {
+		return kubewarden.AcceptSettings()
+	}
+
+	for _, warning := range warnings {
+		logEvent(LogLevelWarn, warning, nil)
+	}
+
+	msg := fmt.Sprintf("Provided settings are valid, but: %s", strings.Join(warnings, "; "))
+	return json.Marshal(kubewarden_protocol.SettingsValidationResponse{
+		Valid:   true,
+		Message: &msg,
+	})
+}
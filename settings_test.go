@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
 
 	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
@@ -100,6 +101,22 @@ func TestDetectValidSettings(t *testing.T) {
 	}
 }
 
+func TestDetectValidNullSettings(t *testing.T) {
+	responsePayload, err := validateSettings([]byte("null"))
+	if err != nil {
+		t.Errorf("Unexpected error %+v", err)
+	}
+
+	var response kubewarden_protocol.SettingsValidationResponse
+	if err := json.Unmarshal(responsePayload, &response); err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+
+	if !response.Valid {
+		t.Errorf("Expected settings to be valid: %s", *response.Message)
+	}
+}
+
 func TestDetectNotValidSettingsDueToBrokenRegexp(t *testing.T) {
 	request := `
 	{
@@ -125,7 +142,7 @@ func TestDetectNotValidSettingsDueToBrokenRegexp(t *testing.T) {
 		t.Error("Expected settings to not be valid")
 	}
 
-	if *response.Message != "Provided settings are not valid: error parsing regexp: missing closing ]: `[a+`" {
+	if *response.Message != "Provided settings are not valid: /constrained_labels/cost-center: invalid regex: missing closing ]: `[a+`" {
 		t.Errorf("Unexpected validation error message: %s", *response.Message)
 	}
 }
@@ -191,3 +208,228 @@ func TestDetectNotValidSettingsDueToConflictingDeniedAndMandatoryLabels(t *testi
 		t.Errorf("Unexpected validation error message: %s", *response.Message)
 	}
 }
+
+func TestParseSettingsWithRulesInLongForm(t *testing.T) {
+	request := `
+	{
+		"denied_labels": [ "foo", { "key": "bar", "message": "{{key}} is reserved" } ],
+		"mandatory_labels": [ { "key": "owner", "message": "add {{key}}" } ],
+		"constrained_labels": {
+			"cost-center": "cc-\\d+",
+			"owner": { "pattern": "^team-", "message": "owner must match {{pattern}}" },
+			"env": { "allowed_values": [ "prod", "dev.1" ] }
+		}
+	}
+	`
+
+	settings, err := NewSettingsFromValidateSettingsPayload([]byte(request))
+	if err != nil {
+		t.Fatalf("Unexpected error %+v", err)
+	}
+
+	if !settings.DeniedLabels.Contains("foo", "bar") {
+		t.Errorf("Missing denied labels: %v", settings.DeniedLabels)
+	}
+	if settings.RuleOptions(RuleRef{DeniedLabelsRule, "foo"}).Message != nil {
+		t.Error("Didn't expect a message for the foo rule")
+	}
+	if msg := settings.RuleOptions(RuleRef{DeniedLabelsRule, "bar"}).Message; msg == nil || msg.String() != "{{key}} is reserved" {
+		t.Errorf("Unexpected message for the bar rule: %v", msg)
+	}
+	if !settings.MandatoryLabels.Contains("owner") {
+		t.Error("Missing mandatory label owner")
+	}
+	if settings.ConstrainedLabels["owner"].String() != "^team-" {
+		t.Errorf("Unexpected owner constraint: %s", settings.ConstrainedLabels["owner"])
+	}
+
+	env := settings.ConstrainedLabels["env"]
+	if !env.MatchString("dev.1") || env.MatchString("dev-1") || env.MatchString("production") {
+		t.Errorf("Unexpected env constraint: %s", env)
+	}
+	if strings.Join(settings.AllowedValues["env"], ",") != "prod,dev.1" {
+		t.Errorf("Unexpected allowed values: %v", settings.AllowedValues["env"])
+	}
+}
+
+func TestDetectNotValidSettingsDueToUnknownMessagePlaceholder(t *testing.T) {
+	request := `
+	{
+		"mandatory_labels": [ { "key": "owner", "message": "add {{label}}" } ]
+	}
+	`
+	responsePayload, err := validateSettings([]byte(request))
+	if err != nil {
+		t.Errorf("Unexpected error %+v", err)
+	}
+
+	var response kubewarden_protocol.SettingsValidationResponse
+	if err := json.Unmarshal(responsePayload, &response); err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+
+	if response.Valid {
+		t.Error("Expected settings to not be valid")
+	}
+
+	if !strings.Contains(*response.Message, "unknown placeholder {{label}}") {
+		t.Errorf("Unexpected validation error message: %s", *response.Message)
+	}
+}
+
+func TestDetectNotValidSettingsDueToConstraintWithPatternAndAllowedValues(t *testing.T) {
+	request := `
+	{
+		"constrained_labels": {
+			"env": { "pattern": "^prod$", "allowed_values": [ "prod" ] }
+		}
+	}
+	`
+	responsePayload, err := validateSettings([]byte(request))
+	if err != nil {
+		t.Errorf("Unexpected error %+v", err)
+	}
+
+	var response kubewarden_protocol.SettingsValidationResponse
+	if err := json.Unmarshal(responsePayload, &response); err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+
+	if response.Valid {
+		t.Error("Expected settings to not be valid")
+	}
+}
+
+func TestDetectNotValidSettingsDueToDuplicatedRuleIDs(t *testing.T) {
+	request := `
+	{
+		"denied_labels": [ { "key": "foo", "id": "LBL-001" } ],
+		"mandatory_labels": [ { "key": "owner", "id": "LBL-001", "severity": "urgent" } ]
+	}
+	`
+	responsePayload, err := validateSettings([]byte(request))
+	if err != nil {
+		t.Errorf("Unexpected error %+v", err)
+	}
+
+	var response kubewarden_protocol.SettingsValidationResponse
+	if err := json.Unmarshal(responsePayload, &response); err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+
+	if response.Valid {
+		t.Error("Expected settings to not be valid")
+	}
+
+	expectedErrorMsg := "Provided settings are not valid: " +
+		"mandatory_labels rule owner has invalid severity \"urgent\", must be one of: low, medium, high, critical; " +
+		"rule ID LBL-001 is used by both denied_labels rule foo and mandatory_labels rule owner"
+	if *response.Message != expectedErrorMsg {
+		t.Errorf("Unexpected validation error message: %s", *response.Message)
+	}
+}
+
+func TestDetectNotValidSettingsDueToInvalidCodes(t *testing.T) {
+	request := `
+	{
+		"denied_labels": [ { "key": "foo", "code": 200 } ],
+		"codes": { "denied_labels": 403, "mandatory": 422, "default": 600 }
+	}
+	`
+	responsePayload, err := validateSettings([]byte(request))
+	if err != nil {
+		t.Errorf("Unexpected error %+v", err)
+	}
+
+	var response kubewarden_protocol.SettingsValidationResponse
+	if err := json.Unmarshal(responsePayload, &response); err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+
+	if response.Valid {
+		t.Error("Expected settings to not be valid")
+	}
+
+	expectedErrorMsg := "Provided settings are not valid: " +
+		"code 600 of default is not valid, must be between 400 and 599; " +
+		"unknown category \"mandatory\" inside of codes; " +
+		"denied_labels rule foo has invalid code 200, must be between 400 and 599"
+	if *response.Message != expectedErrorMsg {
+		t.Errorf("Unexpected validation error message: %s", *response.Message)
+	}
+}
+
+func TestDetectNotValidSettingsDueToInvalidLabelKeys(t *testing.T) {
+	request := `
+	{
+		"denied_labels": [ "foo", "app.kubernetes.io//name" ],
+		"mandatory_labels": [ "owner" ],
+		"constrained_labels": {
+			"Example.com/cost-center": "cc-\\d+"
+		}
+	}
+	`
+	responsePayload, err := validateSettings([]byte(request))
+	if err != nil {
+		t.Errorf("Unexpected error %+v", err)
+	}
+
+	var response kubewarden_protocol.SettingsValidationResponse
+	if err := json.Unmarshal(responsePayload, &response); err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+
+	if response.Valid {
+		t.Error("Expected settings to not be valid")
+	}
+
+	expectedErrorMsg := "Provided settings are not valid: " +
+		"/denied_labels/1: \"app.kubernetes.io//name\" is not a valid label key: " +
+		"name part must consist of alphanumeric characters, '-', '_' or '.', " +
+		"and must start and end with an alphanumeric character; " +
+		"/constrained_labels/Example.com~1cost-center: \"Example.com/cost-center\" is not a valid label key: " +
+		"prefix part must be a lowercase DNS subdomain, like 'example.com'"
+	if *response.Message != expectedErrorMsg {
+		t.Errorf("Unexpected validation error message: %s", *response.Message)
+	}
+}
+
+func TestDetectNotValidSettingsDueToUnknownFieldsAndWrongTypes(t *testing.T) {
+	request := `
+	{
+		"denied_label": [ "foo" ],
+		"mandatory_labels": [ "owner", 42, { "key": "team", "severity": 1 } ],
+		"constrained_labels": {
+			"owner": "team-(",
+			"cost-center": { "pattern": "cc-\\d+", "messages": "typo" }
+		},
+		"check_label_syntax": "yes",
+		"codes": { "denied_labels": 403.5 }
+	}
+	`
+	responsePayload, err := validateSettings([]byte(request))
+	if err != nil {
+		t.Errorf("Unexpected error %+v", err)
+	}
+
+	var response kubewarden_protocol.SettingsValidationResponse
+	if err := json.Unmarshal(responsePayload, &response); err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+
+	if response.Valid {
+		t.Error("Expected settings to not be valid")
+	}
+
+	expectedErrorMsg := "Provided settings are not valid: " +
+		"/denied_label: unknown field, did you mean \"denied_labels\"?; " +
+		"/mandatory_labels/1: expected a string or an object, got a number; " +
+		"/mandatory_labels/2/severity: expected a string, got a number; " +
+		"/constrained_labels/owner: invalid regex: missing closing ): `team-(`; " +
+		"/constrained_labels/cost-center/messages: unknown field, did you mean \"message\"?; " +
+		"/check_label_syntax: expected a boolean, got a string; " +
+		"/codes/denied_labels: expected an integer between 0 and 65535, got 403.5"
+	if *response.Message != expectedErrorMsg {
+		t.Errorf("Unexpected validation error message: %s", *response.Message)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/kubewarden/gjson"
+)
+
+// Returns the sticky labels that the request removes from the object,
+// sorted. Labels can be removed only by UPDATE requests
+func (s *Settings) removedStickyLabels(payload []byte, labelValues map[string]string) []string {
+	removed := []string{}
+	if s.stickyKeys == nil || gjson.GetBytes(payload, "request.operation").String() != "UPDATE" {
+		return removed
+	}
+
+	oldLabels := gjson.GetBytes(payload, "request.oldObject.metadata.labels")
+	oldLabels.ForEach(func(key, _ gjson.Result) bool {
+		label := key.String()
+		if _, found := labelValues[label]; found {
+			return true
+		}
+		if _, sticky := s.stickyKeys.match(label); sticky {
+			removed = append(removed, label)
+		}
+		return true
+	})
+	sort.Strings(removed)
+	return removed
+}
+
+// Checks that the sticky labels are not denied: objects carrying them
+// could not be updated anymore. Their key patterns are bound by the same
+// complexity limit of the denied ones
+func (s *Settings) validateStickyLabels() []string {
+	errors := []string{}
+	if s.stickyKeys != nil && s.stickyKeys.programSize > s.Limits.maxKeyPatternsProgramSize() {
+		errors = append(errors, fmt.Sprintf(
+			"the sticky key patterns are too complex: they compile to %d instructions, the limit is %d",
+			s.stickyKeys.programSize, s.Limits.maxKeyPatternsProgramSize()))
+	}
+	for _, key := range s.StickyLabels {
+		if isKeyPattern(key) {
+			continue
+		}
+		if _, denied := s.deniedRule(key); denied {
+			errors = append(errors, fmt.Sprintf("label %s cannot be denied and sticky at the same time", key))
+		}
+	}
+	return errors
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+	kubewarden_testing "github.com/kubewarden/policy-sdk-go/testing"
+)
+
+func TestStickyLabels(t *testing.T) {
+	cases := []struct {
+		name             string
+		fixture          string
+		sticky           string
+		expectedAccepted bool
+		expectedMessage  string
+	}{
+		{
+			name:            "sticky label removed",
+			fixture:         "test_data/pod_update.json",
+			sticky:          `[ "platform.example.com/zone" ]`,
+			expectedMessage: "The following labels cannot be removed: platform.example.com/zone",
+		},
+		{
+			name:            "sticky label removed, matched by a pattern",
+			fixture:         "test_data/pod_update.json",
+			sticky:          `[ "platform.example.com/*" ]`,
+			expectedMessage: "The following labels cannot be removed: platform.example.com/zone",
+		},
+		{
+			name:             "sticky label changing value",
+			fixture:          "test_data/pod_update.json",
+			sticky:           `[ "platform.example.com/cost" ]`,
+			expectedAccepted: true,
+		},
+		{
+			name:             "sticky label missing from a new object",
+			fixture:          "test_data/pod.json",
+			sticky:           `[ "backup-policy" ]`,
+			expectedAccepted: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			settings, err := NewSettingsFromValidateSettingsPayload([]byte(`
+			{
+				"version": 2,
+				"sticky_labels": ` + tc.sticky + `
+			}`))
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+			if valid, err := settings.Valid(); !valid {
+				t.Fatalf("Expected settings to be valid: %v", err)
+			}
+
+			payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+				tc.fixture,
+				&settings)
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			responsePayload, err := validate(payload)
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			var response kubewarden_protocol.ValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			if response.Accepted != tc.expectedAccepted {
+				t.Fatalf("Unexpected response: %+v", response)
+			}
+			if tc.expectedAccepted {
+				return
+			}
+			if *response.Message != tc.expectedMessage {
+				t.Errorf("Unexpected rejection message: %s", *response.Message)
+			}
+		})
+	}
+}
+
+func TestDetectNotValidStickyLabels(t *testing.T) {
+	cases := []struct {
+		name            string
+		settings        string
+		expectedMessage string
+	}{
+		{
+			name:            "not valid key pattern",
+			settings:        `"sticky_labels": [ "-backup/*" ]`,
+			expectedMessage: `/sticky_labels/0: "-backup/*" is not a valid label key pattern`,
+		},
+		{
+			name: "denied sticky label",
+			settings: `"sticky_labels": [ "backup-policy" ],
+				"rules": [ { "type": "denied", "key": "backup-*" } ]`,
+			expectedMessage: "label backup-policy cannot be denied and sticky at the same time",
+		},
+		{
+			name: "key patterns too complex",
+			settings: `"sticky_labels": [ "*-backup", "*-retention" ],
+				"limits": { "max_key_patterns_program_size": 10 }`,
+			expectedMessage: "the sticky key patterns are too complex: they compile to",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			responsePayload, err := validateSettings([]byte(`
+			{
+				"version": 2,
+				` + tc.settings + `
+			}`))
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			var response kubewarden_protocol.SettingsValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			if response.Valid {
+				t.Fatal("Expected settings to not be valid")
+			}
+			if !strings.Contains(*response.Message, tc.expectedMessage) {
+				t.Errorf("Unexpected message: %s", *response.Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Maximum edit distance between two strings for one of them to be
+// considered a typo of the other one
+const maxSuggestionDistance = 2
+
+// Computes the edit distance between two strings, transpositions of
+// adjacent characters count as a single edit
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	rows := make([][]int, len(ra)+1)
+	for i := range rows {
+		rows[i] = make([]int, len(rb)+1)
+		rows[i][0] = i
+	}
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			rows[i][j] = min(
+				rows[i-1][j]+1,
+				rows[i][j-1]+1,
+				rows[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				rows[i][j] = min(rows[i][j], rows[i-2][j-2]+1)
+			}
+		}
+	}
+
+	return rows[len(ra)][len(rb)]
+}
+
+// Returns true when `candidate` is close enough to `target` to be
+// considered a typo of it. Short strings need to be closer, otherwise
+// every pair of short words would be reported
+func isNearMiss(target, candidate string) bool {
+	if target == candidate {
+		return false
+	}
+	distance := editDistance(target, candidate)
+	return distance <= maxSuggestionDistance && distance*2 <= len([]rune(target))
+}
+
+// Returns the candidate that is the most likely typo of `target`.
+// Ties are resolved picking the candidate that comes first alphabetically
+func closestNearMiss(target string, candidates []string) (string, bool) {
+	sorted := append([]string{}, candidates...)
+	sort.Strings(sorted)
+
+	best := ""
+	bestDistance := -1
+	for _, candidate := range sorted {
+		if !isNearMiss(target, candidate) {
+			continue
+		}
+		distance := editDistance(target, candidate)
+		if bestDistance == -1 || distance < bestDistance {
+			best = candidate
+			bestDistance = distance
+		}
+	}
+
+	return best, bestDistance != -1
+}
+
+// Returns the labels of the object that are not referenced by any rule
+func (s *Settings) unknownLabels(labelValues map[string]string) []string {
+	unknown := []string{}
+	for label := range labelValues {
+		if s.MandatoryLabels.Contains(label) {
+			continue
+		}
+		if _, denied := s.deniedRule(label); denied {
+			continue
+		}
+		if _, constrained := s.ConstrainedLabels[label]; constrained {
+			continue
+		}
+		unknown = append(unknown, label)
+	}
+	return unknown
+}
+
+// Builds "did you mean" hints for the missing mandatory labels and for
+// the constrained labels whose value is not one of the allowed ones
+func (s *Settings) suggestions(
+	missingMandatoryLabels []string,
+	constrainedLabelsViolations []string,
+	labelValues map[string]string,
+) []string {
+	hints := []string{}
+
+	unknownLabels := s.unknownLabels(labelValues)
+	for _, missing := range missingMandatoryLabels {
+		if typo, found := closestNearMiss(missing, unknownLabels); found {
+			hints = append(hints, fmt.Sprintf("Did you mean %q instead of %q?", missing, typo))
+		}
+	}
+
+	for _, label := range constrainedLabelsViolations {
+		allowedValues := s.AllowedValues[label]
+		if len(allowedValues) == 0 {
+			continue
+		}
+		value := labelValues[label]
+		if suggestion, found := closestNearMiss(value, allowedValues); found {
+			hints = append(hints, fmt.Sprintf(
+				"Did you mean %s=%s instead of %s=%s?", label, suggestion, label, value))
+		}
+	}
+
+	return hints
+}
+
+// Returns the labels of the object that look like typos of the labels
+// referenced by the mandatory and constrained rules
+func (s *Settings) nearMissLabels(labelValues map[string]string) []string {
+	targets := s.MandatoryLabels.ToSlice()
+	for label := range s.ConstrainedLabels {
+		targets = append(targets, label)
+	}
+
+	nearMisses := []string{}
+	for _, label := range s.unknownLabels(labelValues) {
+		if _, found := closestNearMiss(label, targets); found {
+			nearMisses = append(nearMisses, label)
+		}
+	}
+	sort.Strings(nearMisses)
+
+	return nearMisses
+}
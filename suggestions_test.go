@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestEditDistance(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		expected int
+	}{
+		{"owner", "owner", 0},
+		{"owner", "ownr", 1},
+		{"prod", "prdo", 1},
+		{"prod", "staging", 7},
+		{"", "app", 3},
+	}
+
+	for _, tc := range cases {
+		if distance := editDistance(tc.a, tc.b); distance != tc.expected {
+			t.Errorf("distance between %q and %q: expected %d, got %d",
+				tc.a, tc.b, tc.expected, distance)
+		}
+	}
+}
+
+func TestClosestNearMiss(t *testing.T) {
+	suggestion, found := closestNearMiss("prdo", []string{"staging", "prod", "dev"})
+	if !found || suggestion != "prod" {
+		t.Errorf("Expected prod to be suggested, got %q", suggestion)
+	}
+
+	// short strings have to be really close to be considered typos
+	if suggestion, found := closestNearMiss("ab", []string{"cd"}); found {
+		t.Errorf("Didn't expect a suggestion, got %q", suggestion)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kubewarden/gjson"
+	"github.com/kubewarden/policy-sdk-go/pkg/capabilities/kubernetes"
+)
+
+// The tenant policies already decoded, tenants usually don't change them
+// often. The cache holds the policies of many namespaces
+var parsedTenantPolicies = settingsCache{capacity: tenantPoliciesCacheSize}
+
+// A tenant policy that cannot be decoded, that is not valid or that tries
+// to use the settings reserved to the cluster administrators
+type brokenTenantPolicyError struct {
+	policy string
+	err    error
+}
+
+func (e *brokenTenantPolicyError) Error() string {
+	return fmt.Sprintf("tenant policy %s is broken: %v", e.policy, e.err)
+}
+
+func (e *brokenTenantPolicyError) Unwrap() error {
+	return e.err
+}
+
+// Returns the settings, reserved to the cluster administrators, that are
+// used by the tenant policy. These include the options of the rules and
+// the settings shaping the rejection responses, which tenant policies
+// cannot change
+func (s *Settings) clusterOnlySettings() []string {
+	var message, id, severity, docsURL, controls, code bool
+	for _, options := range s.Options {
+		message = message || options.Message != nil
+		id = id || options.ID != ""
+		severity = severity || options.Severity != ""
+		docsURL = docsURL || options.DocsURL != ""
+		controls = controls || len(options.Controls) > 0
+		code = code || options.Code != 0
+	}
+
+	fields := []string{}
+	for _, field := range []struct {
+		name string
+		used bool
+	}{
+		{"context_aware", s.ContextAware},
+		{"profiles", len(s.Profiles) > 0},
+		{"profile_selectors", len(s.ProfileSelectors) > 0},
+		{"namespace", !s.Namespace.isZero()},
+		{"owners", !s.Owners.isZero()},
+		{"label_owners", len(s.LabelOwners) > 0},
+		{"conditions", len(s.Conditions) > 0},
+		{"allowed_labels", s.AllowedLabels != nil},
+		{"sticky_labels", len(s.StickyLabels) > 0},
+		{"tenant_policies", s.TenantPolicies != nil},
+		{"limits", !s.Limits.isZero()},
+		{"codes", len(s.Codes) > 0},
+		{"reject_near_miss_labels", s.RejectNearMissLabels},
+		{"check_label_syntax", s.CheckLabelSyntax},
+		{"analysis", s.Analysis != ""},
+		{"message", message},
+		{"id", id},
+		{"severity", severity},
+		{"docs_url", docsURL},
+		{"controls", controls},
+		{"code", code},
+	} {
+		if field.used {
+			fields = append(fields, field.name)
+		}
+	}
+	return fields
+}
+
+// Decodes the spec of a tenant policy and checks that it can be applied on
+// top of the settings. Tenant policies can only make the settings stricter:
+// denying a label that the settings make mandatory breaks the policy
+func (s *Settings) tenantPolicy(spec gjson.Result) (Settings, error) {
+	if !spec.IsObject() {
+		return Settings{}, fmt.Errorf("spec must be an object")
+	}
+	// tenant policies are bound to the size limit of the settings, they
+	// cannot lower it
+	if err := checkSettingsSize([]byte(spec.Raw), s.Limits); err != nil {
+		return Settings{}, err
+	}
+	policy, err := parsedTenantPolicies.get([]byte(spec.Raw))
+	if err != nil {
+		return Settings{}, err
+	}
+
+	if fields := policy.clusterOnlySettings(); len(fields) > 0 {
+		return Settings{}, fmt.Errorf("%s can be set only by the cluster settings", strings.Join(fields, ", "))
+	}
+	// tenant policies cannot set limits, they are bound to the ones of
+	// the settings
+	policy.Limits = s.Limits
+	if valid, err := policy.Valid(); !valid {
+		return Settings{}, err
+	}
+
+	if mandatory := policy.deniedAmong(s.MandatoryLabels).ToSlice(); len(mandatory) > 0 {
+		sort.Strings(mandatory)
+		return Settings{}, fmt.Errorf("labels %s are mandatory, they cannot be denied", strings.Join(mandatory, ","))
+	}
+	for _, rule := range policy.Rules() {
+		if rule.Type == ConstrainedRule && rule.Pattern == nil {
+			return Settings{}, fmt.Errorf(
+				"the constraint of label %s must be a pattern or a list of allowed values", rule.Key)
+		}
+	}
+	return policy, nil
+}
+
+// Adds the rules of a tenant policy, checked by tenantPolicy, to the
+// requirements of the namespace. Mandatory labels and constraints about
+// labels denied by the settings are ignored
+func (s *Settings) addTenantPolicy(requirements *namespaceRequirements, policy Settings) {
+	for _, rule := range policy.Rules() {
+		if rule.Type == DeniedRule {
+			requirements.denied = append(requirements.denied, rule.Key)
+			requirements.deniedKeys = nil
+			continue
+		}
+		if _, denied := s.deniedRule(rule.Key); denied {
+			continue
+		}
+		switch rule.Type {
+		case MandatoryRule:
+			requirements.mandatory.Add(rule.Key)
+		case ConstrainedRule:
+			requirements.constrained[rule.Key] = append(requirements.constrained[rule.Key], rule.Pattern)
+		}
+	}
+}
+
+// Returns true when the object being validated is a tenant policy
+func (s *Settings) isTenantPolicy(object gjson.Result) bool {
+	return s.TenantPolicies != nil &&
+		object.Get("apiVersion").String() == s.TenantPolicies.APIVersion &&
+		object.Get("kind").String() == s.TenantPolicies.Kind
+}
+
+// Adds the rules of the tenant policies defined inside of the namespace
+// to its requirements. The policy named `skipped`, which is being
+// replaced by the admission request, is ignored. Broken policies are
+// reported using brokenTenantPolicyError
+func (s *Settings) addTenantPolicies(requirements *namespaceRequirements, namespace, skipped string) error {
+	if s.TenantPolicies == nil || !s.ContextAware || namespace == "" {
+		return nil
+	}
+
+	response, err := kubernetes.ListResourcesByNamespace(&host, kubernetes.ListResourcesByNamespaceRequest{
+		APIVersion: s.TenantPolicies.APIVersion,
+		Kind:       s.TenantPolicies.Kind,
+		Namespace:  namespace,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot look up %s objects: %w", s.TenantPolicies.Kind, err)
+	}
+	if !gjson.ValidBytes(response) {
+		return fmt.Errorf("cannot look up %s objects: the host returned a malformed list", s.TenantPolicies.Kind)
+	}
+
+	for _, item := range gjson.GetBytes(response, "items").Array() {
+		policyName := item.Get("metadata.name").String()
+		if skipped != "" && policyName == skipped {
+			continue
+		}
+		name := fmt.Sprintf("%s/%s", namespace, policyName)
+		policy, err := s.tenantPolicy(item.Get("spec"))
+		if err != nil {
+			return &brokenTenantPolicyError{name, err}
+		}
+		s.addTenantPolicy(requirements, policy)
+	}
+	return nil
+}
+
+// Checks the source of the tenant policies, which needs context-aware
+// lookups
+func (s *Settings) validateTenantPolicies() []string {
+	errors := []string{}
+	if s.TenantPolicies != nil && !s.ContextAware {
+		errors = append(errors, "tenant policies require context_aware to be enabled")
+	}
+	return errors
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+	kubewarden_testing "github.com/kubewarden/policy-sdk-go/testing"
+)
+
+func TestTenantPolicies(t *testing.T) {
+	cases := []struct {
+		name             string
+		rules            string
+		limits           string
+		spec             string
+		expectedAccepted bool
+		expectedMessage  string
+	}{
+		{
+			name:             "no tenant policy",
+			rules:            `[ { "type": "mandatory", "key": "app" } ]`,
+			expectedAccepted: true,
+		},
+		{
+			name:            "mandatory labels added by the tenant",
+			rules:           `[ { "type": "mandatory", "key": "app" } ]`,
+			spec:            `{ "version": 2, "rules": [ { "type": "mandatory", "key": "owner" } ] }`,
+			expectedMessage: "The following mandatory labels are missing: owner",
+		},
+		{
+			name:            "labels denied by the tenant",
+			rules:           `[ { "type": "mandatory", "key": "app" } ]`,
+			spec:            `{ "denied_labels": [ "own*" ] }`,
+			expectedMessage: "The following labels are denied: ownr",
+		},
+		{
+			name:  "constraints added on top of the ones of the settings",
+			rules: `[ { "type": "constrained", "key": "environment", "pattern": "^[a-z]+$" } ]`,
+			spec: `{ "version": 2, "rules": [
+				{ "type": "constrained", "key": "environment", "allowed_values": [ "prod", "dev" ] }
+			] }`,
+			expectedMessage: "The following labels are violating user constraints: environment",
+		},
+		{
+			name:            "tenants cannot require labels denied by the settings",
+			rules:           `[ { "type": "denied", "key": "app" } ]`,
+			spec:            `{ "version": 2, "rules": [ { "type": "mandatory", "key": "app" } ] }`,
+			expectedMessage: "The following labels are denied: app",
+		},
+		{
+			name:  "tenant policy that cannot be decoded",
+			rules: `[ { "type": "mandatory", "key": "app" } ]`,
+			spec:  `{ "version": 2, "rulez": [] }`,
+			expectedMessage: "The tenant policy payments/labels is broken: " +
+				`/rulez: unknown field, did you mean "rules"?`,
+		},
+		{
+			name:  "tenant policy denying a mandatory label",
+			rules: `[ { "type": "mandatory", "key": "app" } ]`,
+			spec:  `{ "version": 2, "rules": [ { "type": "denied", "key": "app" } ] }`,
+			expectedMessage: "The tenant policy payments/labels is broken: " +
+				"labels app are mandatory, they cannot be denied, " +
+				"objects cannot be validated inside of namespace payments until it is fixed",
+		},
+		{
+			name:  "tenant policy using the settings of the cluster administrators",
+			rules: `[ { "type": "mandatory", "key": "app" } ]`,
+			spec:  `{ "version": 2, "context_aware": true, "profiles": { "lax": {} } }`,
+			expectedMessage: "The tenant policy payments/labels is broken: " +
+				"context_aware, profiles can be set only by the cluster settings",
+		},
+		{
+			name:  "tenant policy shaping the rejection responses",
+			rules: `[ { "type": "mandatory", "key": "app" } ]`,
+			spec: `{ "version": 2, "codes": { "default": 403 }, "check_label_syntax": true, "rules": [
+				{ "type": "mandatory", "key": "owner", "message": "owner is missing", "code": 412 }
+			] }`,
+			expectedMessage: "The tenant policy payments/labels is broken: " +
+				"codes, check_label_syntax, message, code can be set only by the cluster settings",
+		},
+		{
+			name:   "tenant policy beyond the limits of the settings",
+			rules:  `[ { "type": "mandatory", "key": "app" } ]`,
+			limits: `{ "max_pattern_length": 10 }`,
+			spec: `{ "version": 2, "rules": [
+				{ "type": "constrained", "key": "environment", "pattern": "^(prod|staging|dev)$" }
+			] }`,
+			expectedMessage: "The tenant policy payments/labels is broken: " +
+				"the pattern of constrained label environment is 20 characters long, the limit is 10",
+		},
+		{
+			name:  "tenant policy that is too big",
+			rules: `[ { "type": "mandatory", "key": "app" } ]`,
+			spec:  `{ "denied_labels": [ "` + strings.Repeat("a", DefaultMaxSettingsSize) + `" ] }`,
+			expectedMessage: "The tenant policy payments/labels is broken: " +
+				"the settings are 1048603 bytes long, the limit is 1048576",
+		},
+		{
+			name:  "tenant policy that is not valid",
+			rules: `[ { "type": "mandatory", "key": "app" } ]`,
+			spec: `{ "version": 2, "rules": [
+				{ "type": "mandatory", "key": "owner" },
+				{ "type": "denied", "key": "owner" }
+			] }`,
+			expectedMessage: "The tenant policy payments/labels is broken: " +
+				"These labels cannot be mandatory and denied at the same time: owner",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			objects := map[string]string{}
+			if tc.spec != "" {
+				objects[objectKey("LabelPolicy", "payments", "labels")] = `{
+					"metadata": { "name": "labels", "namespace": "payments" },
+					"spec": ` + tc.spec + `
+				}`
+			}
+			useFakeCluster(t, &fakeCluster{objects: objects})
+
+			limits := "{}"
+			if tc.limits != "" {
+				limits = tc.limits
+			}
+			settings, err := NewSettingsFromValidateSettingsPayload([]byte(`
+			{
+				"version": 2,
+				"context_aware": true,
+				"tenant_policies": { "api_version": "labels.policy.example.com/v1", "kind": "LabelPolicy" },
+				"limits": ` + limits + `,
+				"rules": ` + tc.rules + `
+			}`))
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+			if valid, err := settings.Valid(); !valid {
+				t.Fatalf("Expected settings to be valid: %v", err)
+			}
+
+			payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+				"test_data/pod.json",
+				&settings)
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			responsePayload, err := validate(payload)
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			var response kubewarden_protocol.ValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			if response.Accepted != tc.expectedAccepted {
+				t.Fatalf("Unexpected response: %+v", response)
+			}
+			if tc.expectedAccepted {
+				return
+			}
+			if !strings.HasPrefix(*response.Message, tc.expectedMessage) {
+				t.Errorf("Unexpected rejection message: %s", *response.Message)
+			}
+		})
+	}
+}
+
+func TestTenantPoliciesRequireContextAwareLookups(t *testing.T) {
+	responsePayload, err := validateSettings([]byte(`
+	{
+		"version": 2,
+		"tenant_policies": { "api_version": "labels.policy.example.com/v1", "kind": "LabelPolicy" }
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	var response kubewarden_protocol.SettingsValidationResponse
+	if err := json.Unmarshal(responsePayload, &response); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	if response.Valid {
+		t.Fatal("Expected settings to not be valid")
+	}
+	if !strings.Contains(*response.Message, "tenant policies require context_aware to be enabled") {
+		t.Errorf("Unexpected message: %s", *response.Message)
+	}
+}
+
+func TestTenantPoliciesCheckedWhenAdmitted(t *testing.T) {
+	const brokenSpec = `{ "version": 2, "rulez": [] }`
+	cases := []struct {
+		name             string
+		storedSpec       string
+		spec             string
+		expectedAccepted bool
+		expectedMessage  string
+	}{
+		{
+			name:             "valid policy",
+			spec:             `{ "version": 2, "rules": [ { "type": "mandatory", "key": "owner" } ] }`,
+			expectedAccepted: true,
+		},
+		{
+			name: "broken policy",
+			spec: brokenSpec,
+			expectedMessage: "The tenant policy payments/labels is not valid: " +
+				`/rulez: unknown field, did you mean "rules"?`,
+		},
+		{
+			name:            "policy denying a mandatory label",
+			spec:            `{ "denied_labels": [ "app" ] }`,
+			expectedMessage: "The tenant policy payments/labels is not valid: labels app are mandatory, they cannot be denied",
+		},
+		{
+			name:             "broken policy fixed by an update",
+			storedSpec:       brokenSpec,
+			spec:             `{ "version": 2, "rules": [ { "type": "mandatory", "key": "owner" } ] }`,
+			expectedAccepted: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			objects := map[string]string{}
+			if tc.storedSpec != "" {
+				objects[objectKey("LabelPolicy", "payments", "labels")] = `{
+					"metadata": { "name": "labels", "namespace": "payments" },
+					"spec": ` + tc.storedSpec + `
+				}`
+			}
+			useFakeCluster(t, &fakeCluster{objects: objects})
+
+			payload := []byte(`{
+				"request": {
+					"uid": "1",
+					"kind": { "group": "labels.policy.example.com", "version": "v1", "kind": "LabelPolicy" },
+					"namespace": "payments",
+					"operation": "UPDATE",
+					"userInfo": { "username": "bob" },
+					"object": {
+						"apiVersion": "labels.policy.example.com/v1",
+						"kind": "LabelPolicy",
+						"metadata": { "name": "labels", "namespace": "payments", "labels": { "app": "labels" } },
+						"spec": ` + tc.spec + `
+					}
+				},
+				"settings": {
+					"version": 2,
+					"context_aware": true,
+					"tenant_policies": { "api_version": "labels.policy.example.com/v1", "kind": "LabelPolicy" },
+					"rules": [ { "type": "mandatory", "key": "app" } ]
+				}
+			}`)
+
+			responsePayload, err := validate(payload)
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			var response kubewarden_protocol.ValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			if response.Accepted != tc.expectedAccepted {
+				t.Fatalf("Unexpected response: %+v", response)
+			}
+			if tc.expectedAccepted {
+				return
+			}
+			if *response.Message != tc.expectedMessage {
+				t.Errorf("Unexpected rejection message: %s", *response.Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/kubewarden/gjson"
+	"github.com/kubewarden/policy-sdk-go/pkg/capabilities/kubernetes"
+)
+
+// A kind of Kubernetes objects
+type ObjectKind struct {
+	APIVersion string `json:"api_version"`
+	Kind       string `json:"kind"`
+}
+
+// UniqueConstraint requires the value of a label to be unique among the
+// objects of the given kinds, either inside of each namespace or in the
+// whole cluster:
+//
+//	{
+//	  "scope": "namespace",
+//	  "kinds": [{ "api_version": "v1", "kind": "Service" }],
+//	  "values": ["true"]
+//	}
+type UniqueConstraint struct {
+	// One of `referenceScopes`
+	Scope string       `json:"scope"`
+	Kinds []ObjectKind `json:"kinds"`
+	// The values that must be unique, all of them when empty
+	Values []string `json:"values,omitempty"`
+}
+
+// Returns a description of an object, other than the one being validated,
+// that sets the label to the same value. An empty string is returned when
+// there is no such object
+func (u UniqueConstraint) conflict(label, value string, reqCtx requestContext) (string, error) {
+	if len(u.Values) > 0 && !slices.Contains(u.Values, value) {
+		return "", nil
+	}
+	if len(labelValueErrors(value)) > 0 {
+		// cannot be used inside of a selector, no object can set it
+		return "", nil
+	}
+	namespaced := u.Scope == ReferenceScopeNamespace
+	if namespaced && reqCtx.namespace == "" {
+		// cluster-wide objects are not part of any namespace
+		return "", nil
+	}
+
+	selector := label + "=" + value
+	for _, kind := range u.Kinds {
+		var response []byte
+		var err error
+		if namespaced {
+			response, err = kubernetes.ListResourcesByNamespace(&host, kubernetes.ListResourcesByNamespaceRequest{
+				APIVersion:    kind.APIVersion,
+				Kind:          kind.Kind,
+				Namespace:     reqCtx.namespace,
+				LabelSelector: &selector,
+			})
+		} else {
+			response, err = kubernetes.ListResources(&host, kubernetes.ListAllResourcesRequest{
+				APIVersion:    kind.APIVersion,
+				Kind:          kind.Kind,
+				LabelSelector: &selector,
+			})
+		}
+		if err != nil {
+			return "", fmt.Errorf("cannot look up %s objects: %w", kind.Kind, err)
+		}
+		if !gjson.ValidBytes(response) {
+			return "", fmt.Errorf("cannot look up %s objects: the host returned a malformed list", kind.Kind)
+		}
+
+		for _, item := range gjson.GetBytes(response, "items").Array() {
+			name := item.Get("metadata.name").String()
+			namespace := item.Get("metadata.namespace").String()
+			if kind.Kind == reqCtx.kind && name == reqCtx.name && namespace == reqCtx.namespace {
+				// the object being updated
+				continue
+			}
+			if namespace != "" {
+				return fmt.Sprintf("%s %s in namespace %s", kind.Kind, name, namespace), nil
+			}
+			return fmt.Sprintf("%s %s", kind.Kind, name), nil
+		}
+	}
+	return "", nil
+}
+
+// Checks the uniqueness constraints, they need context-aware lookups
+func (s *Settings) validateUniqueConstraints() []string {
+	errors := []string{}
+	if len(s.UniqueConstraints) == 0 || s.ContextAware {
+		return errors
+	}
+
+	labels := make([]string, 0, len(s.UniqueConstraints))
+	for label := range s.UniqueConstraints {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	errors = append(errors, fmt.Sprintf(
+		"constrained labels %s must be unique, which requires context_aware to be enabled",
+		strings.Join(labels, ",")))
+	return errors
+}
+
+// Decodes a uniqueness constraint
+func (d *settingsDecoder) uniqueConstraint(path string, raw json.RawMessage) (*UniqueConstraint, bool) {
+	unique := UniqueConstraint{}
+	errsBefore := len(d.errs)
+
+	d.object(path, raw, fieldDecoders{
+		"scope": func(path string, raw json.RawMessage) {
+			if scope, ok := d.string(path, raw); ok {
+				if !slices.Contains(referenceScopes, scope) {
+					d.fail(path, "must be one of: %s", strings.Join(referenceScopes, ", "))
+					return
+				}
+				unique.Scope = scope
+			}
+		},
+		"kinds": func(path string, raw json.RawMessage) {
+			d.array(path, raw, func(path string, raw json.RawMessage) {
+				if kind, ok := d.objectKind(path, raw); ok {
+					unique.Kinds = append(unique.Kinds, kind)
+				}
+			})
+		},
+		"values": func(path string, raw json.RawMessage) {
+			unique.Values, _ = d.stringList(path, raw)
+		},
+	})
+	if len(d.errs) != errsBefore {
+		return nil, false
+	}
+
+	switch {
+	case unique.Scope == "":
+		d.fail(path, "missing required field scope")
+	case len(unique.Kinds) == 0:
+		d.fail(path, "needs at least one kind")
+	}
+	return &unique, len(d.errs) == errsBefore
+}
+
+// Decodes a kind of Kubernetes objects
+func (d *settingsDecoder) objectKind(path string, raw json.RawMessage) (ObjectKind, bool) {
+	kind := ObjectKind{}
+	errsBefore := len(d.errs)
+
+	d.object(path, raw, fieldDecoders{
+		"api_version": func(path string, raw json.RawMessage) {
+			kind.APIVersion, _ = d.apiVersion(path, raw)
+		},
+		"kind": func(path string, raw json.RawMessage) {
+			kind.Kind, _ = d.kind(path, raw)
+		},
+	})
+	if len(d.errs) != errsBefore {
+		return kind, false
+	}
+
+	switch {
+	case kind.APIVersion == "":
+		d.fail(path, "missing required field api_version")
+	case kind.Kind == "":
+		d.fail(path, "missing required field kind")
+	}
+	return kind, len(d.errs) == errsBefore
+}
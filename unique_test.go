@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+	kubewarden_testing "github.com/kubewarden/policy-sdk-go/testing"
+)
+
+func TestUniqueConstraints(t *testing.T) {
+	otherService := `{"metadata": {"name": "api", "namespace": "payments", "labels": {"app": "web"}}}`
+	serviceInDefault := `{"metadata": {"name": "api", "namespace": "default", "labels": {"app": "web"}}}`
+
+	cases := []struct {
+		name             string
+		unique           string
+		objects          map[string]string
+		expectedAccepted bool
+		expectedMessage  string
+	}{
+		{
+			name:   "value set by another object of the namespace",
+			unique: `{ "scope": "namespace", "kinds": [ { "api_version": "v1", "kind": "Service" } ] }`,
+			objects: map[string]string{
+				objectKey("Service", "payments", "api"): otherService,
+			},
+			expectedMessage: "The following labels must have a unique value: " +
+				"app (already set by Service api in namespace payments)",
+		},
+		{
+			name:   "value set by the object being updated",
+			unique: `{ "scope": "namespace", "kinds": [ { "api_version": "v1", "kind": "Pod" } ] }`,
+			objects: map[string]string{
+				objectKey("Pod", "payments", "web"): `{"metadata": {"name": "web", "namespace": "payments", "labels": {"app": "web"}}}`,
+			},
+			expectedAccepted: true,
+		},
+		{
+			name:   "value set inside of another namespace",
+			unique: `{ "scope": "namespace", "kinds": [ { "api_version": "v1", "kind": "Service" } ] }`,
+			objects: map[string]string{
+				objectKey("Service", "default", "api"): serviceInDefault,
+			},
+			expectedAccepted: true,
+		},
+		{
+			name: "value set inside of another namespace, unique cluster-wide",
+			unique: `{ "scope": "cluster", "kinds": [
+				{ "api_version": "v1", "kind": "Pod" },
+				{ "api_version": "v1", "kind": "Service" }
+			] }`,
+			objects: map[string]string{
+				objectKey("Service", "default", "api"): serviceInDefault,
+			},
+			expectedMessage: "The following labels must have a unique value: " +
+				"app (already set by Service api in namespace default)",
+		},
+		{
+			name:   "value that doesn't need to be unique",
+			unique: `{ "scope": "namespace", "kinds": [ { "api_version": "v1", "kind": "Service" } ], "values": [ "api" ] }`,
+			objects: map[string]string{
+				objectKey("Service", "payments", "api"): otherService,
+			},
+			expectedAccepted: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			useFakeCluster(t, &fakeCluster{objects: tc.objects})
+
+			settings, err := NewSettingsFromValidateSettingsPayload([]byte(`
+			{
+				"version": 2,
+				"context_aware": true,
+				"rules": [ { "type": "constrained", "key": "app", "unique": ` + tc.unique + ` } ]
+			}`))
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+			if valid, err := settings.Valid(); !valid {
+				t.Fatalf("Expected settings to be valid: %v", err)
+			}
+
+			payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+				"test_data/pod.json",
+				&settings)
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			responsePayload, err := validate(payload)
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			var response kubewarden_protocol.ValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			if response.Accepted != tc.expectedAccepted {
+				t.Fatalf("Unexpected response: %+v", response)
+			}
+			if tc.expectedAccepted {
+				return
+			}
+			if *response.Message != tc.expectedMessage {
+				t.Errorf("Unexpected rejection message: %s", *response.Message)
+			}
+		})
+	}
+}
+
+func TestDetectNotValidUniqueConstraints(t *testing.T) {
+	cases := []struct {
+		name            string
+		rule            string
+		expectedMessage string
+	}{
+		{
+			name:            "missing scope",
+			rule:            `"unique": { "kinds": [ { "api_version": "v1", "kind": "Service" } ] }`,
+			expectedMessage: "/rules/0/unique: missing required field scope",
+		},
+		{
+			name:            "missing kinds",
+			rule:            `"unique": { "scope": "cluster", "kinds": [] }`,
+			expectedMessage: "/rules/0/unique: needs at least one kind",
+		},
+		{
+			name:            "not valid kind",
+			rule:            `"unique": { "scope": "cluster", "kinds": [ { "api_version": "v1", "kind": "service" } ] }`,
+			expectedMessage: `/rules/0/unique/kinds/0/kind: "service" is not a valid kind, like Service or Team`,
+		},
+		{
+			name: "both unique and references",
+			rule: `"unique": { "scope": "cluster", "kinds": [ { "api_version": "v1", "kind": "Service" } ] },
+				"references": { "api_version": "v1", "kind": "Namespace" }`,
+			expectedMessage: "/rules/0: cannot have both references and unique",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			responsePayload, err := validateSettings([]byte(`
+			{
+				"version": 2,
+				"context_aware": true,
+				"rules": [ { "type": "constrained", "key": "app-id", ` + tc.rule + ` } ]
+			}`))
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			var response kubewarden_protocol.SettingsValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			if response.Valid {
+				t.Fatal("Expected settings to not be valid")
+			}
+			if !strings.Contains(*response.Message, tc.expectedMessage) {
+				t.Errorf("Unexpected message: %s", *response.Message)
+			}
+		})
+	}
+}
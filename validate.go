@@ -1,7 +1,10 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"slices"
+	"sort"
 	"strings"
 
 	mapset "github.com/deckarep/golang-set/v2"
@@ -9,6 +12,17 @@ import (
 	kubewarden "github.com/kubewarden/policy-sdk-go"
 )
 
+// Details about the admission request that can be referenced by the
+// message templates
+type requestContext struct {
+	namespace string
+	kind      string
+	// The name of the object, empty when it is generated by the API server
+	name string
+	// The settings profile selected for the request, if any
+	profile string
+}
+
 func validate(payload []byte) ([]byte, error) {
 	if !gjson.ValidBytes(payload) {
 		return kubewarden.RejectRequest(
@@ -16,30 +30,118 @@ func validate(payload []byte) ([]byte, error) {
 			kubewarden.Code(400))
 	}
 
-	settings, err := NewSettingsFromValidationReq(payload)
+	decoded, err := NewSettingsFromValidationReq(payload)
 	if err != nil {
 		return kubewarden.RejectRequest(
 			kubewarden.Message(err.Error()),
 			kubewarden.Code(400))
 	}
 
+	reqCtx := requestContext{
+		namespace: gjson.GetBytes(payload, "request.namespace").String(),
+		kind:      gjson.GetBytes(payload, "request.kind.kind").String(),
+		name:      gjson.GetBytes(payload, "request.object.metadata.name").String(),
+	}
+
+	lookup := newNamespaceLookup()
+	settings, profile, err := decoded.forNamespace(reqCtx.namespace, lookup)
+	if err != nil {
+		return kubewarden.RejectRequest(
+			kubewarden.Message(fmt.Sprintf("Cannot select the settings profile: %v", err)),
+			kubewarden.Code(500))
+	}
+	reqCtx.profile = profile
+
+	namespace, err := settings.requestNamespace(reqCtx, lookup)
+	if err != nil {
+		return kubewarden.RejectRequest(
+			kubewarden.Message(fmt.Sprintf("Cannot check the labels of the Namespace: %v", err)),
+			kubewarden.Code(500))
+	}
+	owners, err := settings.ownerChain(
+		gjson.GetBytes(payload, "request.object.metadata.ownerReferences"), reqCtx)
+	if err != nil {
+		return kubewarden.RejectRequest(
+			kubewarden.Message(fmt.Sprintf("Cannot check the labels of the owners: %v", err)),
+			kubewarden.Code(500))
+	}
+
+	requirements, err := settings.namespaceRequirements(namespace)
+	if err != nil {
+		return kubewarden.RejectRequest(
+			kubewarden.Message(fmt.Sprintf(
+				"Namespace %s declares malformed label requirements: %v", namespace.name, err)),
+			kubewarden.Code(400))
+	}
+	// tenant policies are checked when they are admitted, the stored copy
+	// of the one being replaced doesn't apply to the request
+	replacedTenantPolicy := ""
+	if object := gjson.GetBytes(payload, "request.object"); settings.isTenantPolicy(object) {
+		if _, err := settings.tenantPolicy(object.Get("spec")); err != nil {
+			return kubewarden.RejectRequest(
+				kubewarden.Message(fmt.Sprintf(
+					"The tenant policy %s/%s is not valid: %v", reqCtx.namespace, reqCtx.name, err)),
+				kubewarden.Code(400))
+		}
+		replacedTenantPolicy = reqCtx.name
+	}
+	if err := settings.addTenantPolicies(&requirements, reqCtx.namespace, replacedTenantPolicy); err != nil {
+		var broken *brokenTenantPolicyError
+		if errors.As(err, &broken) {
+			return kubewarden.RejectRequest(
+				kubewarden.Message(fmt.Sprintf(
+					"The %v, objects cannot be validated inside of namespace %s until it is fixed",
+					err, reqCtx.namespace)),
+				kubewarden.Code(400))
+		}
+		return kubewarden.RejectRequest(
+			kubewarden.Message(fmt.Sprintf("Cannot look up the tenant policies: %v", err)),
+			kubewarden.Code(500))
+	}
+
 	data := gjson.GetBytes(
 		payload,
 		"request.object.metadata.labels")
 
 	labels := mapset.NewThreadUnsafeSet[string]()
+	labelValues := make(map[string]string)
 	denied_labels_violations := []string{}
+	notAllowedLabels := []string{}
 	constrained_labels_violations := []string{}
+	unreadableConstraints := []string{}
+	// the constraints read from ConfigMaps that cannot be read, and the
+	// ones checked by looking up other objects that cannot be checked
+	unreadableConstraintErrors := []string{}
+	constraintErrors := []string{}
+	configMaps := newConfigMapLookup()
+	references := newReferenceLookup()
+	missingReferents := []string{}
+	referents := make(map[string]string)
+	duplicatedValues := []string{}
+	conflicts := make(map[string]string)
+	labelSyntaxViolations := []string{}
 
 	data.ForEach(func(key, value gjson.Result) bool {
 		label := key.String()
 		labels.Add(label)
+		labelValues[label] = value.String()
+
+		if settings.CheckLabelSyntax {
+			if problems := labelSyntaxErrors(label, value.String()); problems != "" {
+				labelSyntaxViolations = append(labelSyntaxViolations, problems)
+			}
+		}
 
-		if settings.DeniedLabels.Contains(label) {
+		if _, denied := settings.deniedRule(label); denied || requirements.denies(label) {
 			denied_labels_violations = append(denied_labels_violations, label)
 			return true
 		}
 
+		if settings.notAllowed(label) {
+			notAllowedLabels = append(notAllowedLabels, label)
+			return true
+		}
+
 		regExp, found := settings.ConstrainedLabels[label]
 		if found {
 			// This is a constrained label
@@ -49,46 +151,341 @@ func validate(payload []byte) ([]byte, error) {
 			}
 		}
 
+		if source, found := settings.ConfigMapConstraints[label]; found {
+			// The constraint of this label is read from a ConfigMap
+			regExp, err := configMaps.constraint(source, settings.Limits)
+			switch {
+			case err != nil && source.failOpen():
+				logEvent(LogLevelWarn, "cannot read the constraint of the label, accepting its value", map[string]interface{}{
+					"label": label,
+					"error": err.Error(),
+				})
+			case err != nil:
+				unreadableConstraints = append(unreadableConstraints, label)
+				unreadableConstraintErrors = append(unreadableConstraintErrors, fmt.Sprintf("%s (%v)", label, err))
+				return true
+			case !regExp.Match([]byte(value.String())):
+				constrained_labels_violations = append(constrained_labels_violations, label)
+				return true
+			}
+		}
+
+		if ref, found := settings.ReferenceConstraints[label]; found {
+			// The value of this label must refer to an existing object
+			exists, err := references.exists(ref, reqCtx.namespace, value.String())
+			switch {
+			case err != nil:
+				unreadableConstraints = append(unreadableConstraints, label)
+				constraintErrors = append(constraintErrors, fmt.Sprintf("%s (%v)", label, err))
+				return true
+			case !exists:
+				missingReferents = append(missingReferents, label)
+				referents[label] = ref.describe(reqCtx.namespace, value.String())
+				return true
+			}
+		}
+
+		if unique, found := settings.UniqueConstraints[label]; found {
+			// The value of this label must be unique among other objects
+			conflict, err := unique.conflict(label, value.String(), reqCtx)
+			switch {
+			case err != nil:
+				unreadableConstraints = append(unreadableConstraints, label)
+				constraintErrors = append(constraintErrors, fmt.Sprintf("%s (%v)", label, err))
+				return true
+			case conflict != "":
+				duplicatedValues = append(duplicatedValues, label)
+				conflicts[label] = "already set by " + conflict
+				return true
+			}
+		}
+
+		if requirements.violatesConstraints(label, value.String()) {
+			// This label is constrained by the Namespace
+			constrained_labels_violations = append(constrained_labels_violations, label)
+			return true
+		}
+
 		return true
 	})
 
 	errorMsgs := []string{}
 
-	if len(denied_labels_violations) > 0 {
-		errorMsgs = append(
-			errorMsgs,
-			fmt.Sprintf(
-				"The following labels are denied: %s",
-				strings.Join(denied_labels_violations, ","),
-			))
+	if len(labelSyntaxViolations) > 0 {
+		errorMsgs = append(errorMsgs, fmt.Sprintf(
+			"The following labels are not valid: %s",
+			strings.Join(labelSyntaxViolations, "; ")))
+	}
+
+	labelOwnerViolations, labelOwners := settings.labelOwnerViolations(
+		changedLabels(payload, labelValues), requestUserInfo(payload))
+	errorMsgs = append(errorMsgs, settings.detailedViolationMessages(
+		LabelOwnersRule,
+		"The following labels can be changed only by their owners: %s",
+		labelOwnerViolations,
+		labelOwners,
+		labelValues,
+		reqCtx)...)
+
+	errorMsgs = append(errorMsgs, settings.violationMessages(
+		DeniedLabelsRule,
+		"The following labels are denied: %s",
+		denied_labels_violations,
+		labelValues,
+		reqCtx)...)
+
+	errorMsgs = append(errorMsgs, settings.violationMessages(
+		AllowedLabelsRule,
+		"The following labels are not allowed: %s",
+		notAllowedLabels,
+		labelValues,
+		reqCtx)...)
+
+	removedStickyLabels := settings.removedStickyLabels(payload, labelValues)
+	errorMsgs = append(errorMsgs, settings.violationMessages(
+		StickyLabelsRule,
+		"The following labels cannot be removed: %s",
+		removedStickyLabels,
+		labelValues,
+		reqCtx)...)
+
+	errorMsgs = append(errorMsgs, settings.violationMessages(
+		ConstrainedLabelsRule,
+		"The following labels are violating user constraints: %s",
+		constrained_labels_violations,
+		labelValues,
+		reqCtx)...)
+
+	errorMsgs = append(errorMsgs, settings.detailedViolationMessages(
+		ConstrainedLabelsRule,
+		"The following labels refer to objects that do not exist: %s",
+		missingReferents,
+		referents,
+		labelValues,
+		reqCtx)...)
+
+	errorMsgs = append(errorMsgs, settings.detailedViolationMessages(
+		ConstrainedLabelsRule,
+		"The following labels must have a unique value: %s",
+		duplicatedValues,
+		conflicts,
+		labelValues,
+		reqCtx)...)
+
+	if len(unreadableConstraintErrors) > 0 {
+		errorMsgs = append(errorMsgs, fmt.Sprintf(
+			"The constraints of the following labels cannot be read: %s",
+			strings.Join(unreadableConstraintErrors, "; ")))
+	}
+	if len(constraintErrors) > 0 {
+		errorMsgs = append(errorMsgs, fmt.Sprintf(
+			"The constraints of the following labels cannot be checked: %s",
+			strings.Join(constraintErrors, "; ")))
 	}
 
-	if len(constrained_labels_violations) > 0 {
-		errorMsgs = append(
-			errorMsgs,
-			fmt.Sprintf(
-				"The following labels are violating user constraints: %s",
-				strings.Join(constrained_labels_violations, ","),
-			))
+	namespaceMatchViolations := settings.namespaceMatchViolations(labelValues, namespace)
+	if namespace != nil {
+		errorMsgs = append(errorMsgs, settings.violationMessages(
+			NamespaceMatchRule,
+			"The following labels do not match the ones of Namespace "+namespace.name+": %s",
+			namespaceMatchViolations,
+			labelValues,
+			reqCtx)...)
 	}
 
-	mandatoryLabelsViolations := settings.MandatoryLabels.Difference(labels)
-	if mandatoryLabelsViolations.Cardinality() > 0 {
-		violations := mandatoryLabelsViolations.ToSlice()
+	ownerMatchViolations, ownerMismatches := settings.ownerMatchViolations(labelValues, owners)
+	errorMsgs = append(errorMsgs, settings.detailedViolationMessages(
+		OwnerMatchRule,
+		"The following labels do not match the ones of their owners: %s",
+		ownerMatchViolations,
+		ownerMismatches,
+		labelValues,
+		reqCtx)...)
 
-		errorMsgs = append(
-			errorMsgs,
-			fmt.Sprintf(
-				"The following mandatory labels are missing: %s",
-				strings.Join(violations, ","),
-			))
+	conditionMessages, conditionViolations := settings.conditionViolationMessages(labelValues, reqCtx)
+	errorMsgs = append(errorMsgs, conditionMessages...)
+
+	mandatoryLabelsViolations := settings.MandatoryLabels.Union(requirements.mandatory).Difference(labels).ToSlice()
+	sort.Strings(mandatoryLabelsViolations)
+	mandatoryLabelsViolations = settings.withoutInheritedLabels(mandatoryLabelsViolations, namespace)
+	mandatoryLabelsViolations = settings.withoutOwnerInheritedLabels(mandatoryLabelsViolations, owners)
+	errorMsgs = append(errorMsgs, settings.violationMessages(
+		MandatoryLabelsRule,
+		"The following mandatory labels are missing: %s",
+		mandatoryLabelsViolations,
+		labelValues,
+		reqCtx)...)
+
+	nearMissLabelsViolations := []string{}
+	if settings.RejectNearMissLabels {
+		nearMissLabelsViolations = settings.nearMissLabels(labelValues)
+		errorMsgs = append(errorMsgs, settings.violationMessages(
+			NearMissLabelsRule,
+			"The following labels look like typos of other labels: %s",
+			nearMissLabelsViolations,
+			labelValues,
+			reqCtx)...)
 	}
 
 	if len(errorMsgs) > 0 {
+		hints := settings.suggestions(
+			mandatoryLabelsViolations,
+			constrained_labels_violations,
+			labelValues)
+		if len(hints) > 0 {
+			errorMsgs = append(errorMsgs, strings.Join(hints, " "))
+		}
+
+		constrainedViolations := slices.Concat(
+			constrained_labels_violations, missingReferents, duplicatedValues, unreadableConstraints)
+		code := settings.rejectionCode(map[RuleCategory][]string{
+			LabelSyntaxRule:       labelSyntaxViolations,
+			LabelOwnersRule:       labelOwnerViolations,
+			DeniedLabelsRule:      denied_labels_violations,
+			AllowedLabelsRule:     notAllowedLabels,
+			StickyLabelsRule:      removedStickyLabels,
+			ConstrainedLabelsRule: constrainedViolations,
+			NamespaceMatchRule:    namespaceMatchViolations,
+			OwnerMatchRule:        ownerMatchViolations,
+			ConditionsRule:        conditionViolations,
+			MandatoryLabelsRule:   mandatoryLabelsViolations,
+			NearMissLabelsRule:    nearMissLabelsViolations,
+		})
 		return kubewarden.RejectRequest(
 			kubewarden.Message(strings.Join(errorMsgs, ". ")),
-			kubewarden.NoCode)
+			kubewarden.Code(code))
 	}
 
 	return kubewarden.AcceptRequest()
 }
+
+// Builds the messages describing the violations of a category of rules.
+// The violations of rules that have a custom message or some metadata are
+// rendered one by one, all the other ones are reported together using
+// `defaultFormat`
+func (s *Settings) violationMessages(
+	category RuleCategory,
+	defaultFormat string,
+	violations []string,
+	labelValues map[string]string,
+	reqCtx requestContext,
+) []string {
+	return s.detailedViolationMessages(category, defaultFormat, violations, nil, labelValues, reqCtx)
+}
+
+// Like violationMessages, but reports the details of each violation, when
+// available, next to the label. The violations of rules sharing the same
+// metadata are reported together, inside of the same sentence of their
+// category
+func (s *Settings) detailedViolationMessages(
+	category RuleCategory,
+	defaultFormat string,
+	violations []string,
+	details map[string]string,
+	labelValues map[string]string,
+	reqCtx requestContext,
+) []string {
+	msgs := []string{}
+	// the violations grouped by the summary of their metadata, the
+	// violations of rules without metadata come first
+	summaries := []string{""}
+	grouped := map[string][]string{}
+
+	for _, label := range violations {
+		options := s.RuleOptions(s.violatedRule(category, label))
+		logViolation(RuleRef{category, label}, options, reqCtx)
+
+		subject := label
+		if detail, found := details[label]; found {
+			subject = fmt.Sprintf("%s (%s)", label, detail)
+		}
+
+		switch {
+		case options.Message != nil:
+			values := map[string]string{
+				PlaceholderKey:           label,
+				PlaceholderValue:         labelValues[label],
+				PlaceholderAllowedValues: strings.Join(s.AllowedValues[label], ", "),
+				PlaceholderNamespace:     reqCtx.namespace,
+				PlaceholderKind:          reqCtx.kind,
+				PlaceholderID:            options.ID,
+				PlaceholderSeverity:      options.Severity,
+				PlaceholderDocsURL:       options.DocsURL,
+				PlaceholderControls:      strings.Join(options.Controls, ", "),
+			}
+			if re, found := s.ConstrainedLabels[label]; found && re != nil {
+				values[PlaceholderPattern] = re.String()
+			}
+			msgs = append(msgs, options.Message.Render(values))
+		case options.hasMetadata():
+			summary := options.metadataSummary()
+			if _, found := grouped[summary]; !found {
+				summaries = append(summaries, summary)
+			}
+			grouped[summary] = append(grouped[summary], subject)
+		default:
+			grouped[""] = append(grouped[""], subject)
+		}
+	}
+
+	groups := []string{}
+	for _, summary := range summaries {
+		subjects, found := grouped[summary]
+		if !found {
+			continue
+		}
+		group := strings.Join(subjects, ",")
+		if summary != "" {
+			group += " " + summary
+		}
+		groups = append(groups, group)
+	}
+	if len(groups) > 0 {
+		msgs = append(
+			[]string{fmt.Sprintf(defaultFormat, strings.Join(groups, "; "))},
+			msgs...)
+	}
+
+	return msgs
+}
+
+func logViolation(ref RuleRef, options RuleOptions, reqCtx requestContext) {
+	fields := map[string]interface{}{
+		"rule":      string(ref.Category),
+		"label":     ref.Label,
+		"namespace": reqCtx.namespace,
+		"kind":      reqCtx.kind,
+	}
+	if reqCtx.profile != "" {
+		fields["profile"] = reqCtx.profile
+	}
+	if options.ID != "" {
+		fields["rule_id"] = options.ID
+	}
+	if options.Severity != "" {
+		fields["severity"] = options.Severity
+	}
+	if options.DocsURL != "" {
+		fields["docs_url"] = options.DocsURL
+	}
+	if len(options.Controls) > 0 {
+		fields["controls"] = options.Controls
+	}
+	logEvent(LogLevelInfo, "label rule violated", fields)
+}
+
+// Describes the problems of a label that doesn't follow the Kubernetes
+// syntax rules, returns an empty string when the label is valid
+func labelSyntaxErrors(key, value string) string {
+	problems := []string{}
+	if keyErrors := labelKeyErrors(key); len(keyErrors) > 0 {
+		problems = append(problems, "key "+strings.Join(keyErrors, ", "))
+	}
+	if valueErrors := labelValueErrors(value); len(valueErrors) > 0 {
+		problems = append(problems, "value "+strings.Join(valueErrors, ", "))
+	}
+	if len(problems) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s (%s)", key, strings.Join(problems, "; "))
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"os"
 	"regexp"
 	"testing"
 
@@ -39,6 +40,28 @@ func TestEmptySettingsLeadsToRequestAccepted(t *testing.T) {
 	}
 }
 
+func TestNullSettingsLeadsToRequestAccepted(t *testing.T) {
+	request, err := os.ReadFile("test_data/ingress.json")
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	payload := []byte(`{"request": ` + string(request) + `, "settings": null}`)
+
+	responsePayload, err := validate(payload)
+	if err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+
+	var response kubewarden_protocol.ValidationResponse
+	if err := json.Unmarshal(responsePayload, &response); err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+
+	if response.Accepted != true {
+		t.Errorf("Unexpected rejection: %s", *response.Message)
+	}
+}
+
 func TestRequestAccepted(t *testing.T) {
 	settings := Settings{
 		DeniedLabels:    mapset.NewThreadUnsafeSet("bad1", "bad2"),
@@ -221,3 +244,371 @@ func TestRejectionBecauseConstrainedLabelMissing(t *testing.T) {
 		t.Errorf("Got '%s' instead of '%s'", *response.Message, expectedMessage)
 	}
 }
+
+func TestRejectionWithCustomMessages(t *testing.T) {
+	settings, err := NewSettingsFromValidateSettingsPayload([]byte(`
+	{
+		"denied_labels": [ "hello" ],
+		"mandatory_labels": [
+			"required",
+			{ "key": "team", "message": "add {{key}} to the {{kind}}; see https://wiki/labels" }
+		],
+		"constrained_labels": {
+			"owner": {
+				"pattern": "^squad-",
+				"message": "{{key}}={{value}} doesn't match {{pattern}}"
+			},
+			"cc-center": { "allowed_values": [ "cc-1", "cc-2" ], "message": "use one of: {{allowed_values}}" }
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+		"test_data/ingress.json",
+		&settings)
+	if err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+
+	responsePayload, err := validate(payload)
+	if err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+
+	var response kubewarden_protocol.ValidationResponse
+	if err := json.Unmarshal(responsePayload, &response); err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+
+	if response.Accepted != false {
+		t.Error("Unexpected accept response")
+	}
+
+	expectedMessage := "use one of: cc-1, cc-2. " +
+		"owner=team-infra doesn't match ^squad-. " +
+		"The following mandatory labels are missing: required. " +
+		"add team to the Ingress; see https://wiki/labels"
+	if *response.Message != expectedMessage {
+		t.Errorf("Got '%s' instead of '%s'", *response.Message, expectedMessage)
+	}
+}
+
+func TestRejectionMessageIncludesRuleMetadata(t *testing.T) {
+	settings, err := NewSettingsFromValidateSettingsPayload([]byte(`
+	{
+		"denied_labels": [
+			"foo",
+			{
+				"key": "owner",
+				"id": "LBL-001",
+				"severity": "high",
+				"docs_url": "https://wiki/labels",
+				"controls": [ "SOC2-CC6.1", "ISO-A.8.1" ]
+			}
+		],
+		"mandatory_labels": [
+			{
+				"key": "team",
+				"id": "LBL-002",
+				"controls": [ "SOC2-CC1.3" ],
+				"message": "{{key}} is missing, see control {{controls}}"
+			}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+		"test_data/ingress.json",
+		&settings)
+	if err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+
+	responsePayload, err := validate(payload)
+	if err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+
+	var response kubewarden_protocol.ValidationResponse
+	if err := json.Unmarshal(responsePayload, &response); err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+
+	if response.Accepted != false {
+		t.Error("Unexpected accept response")
+	}
+
+	expectedMessage := "The following labels are denied: owner " +
+		"[id: LBL-001, severity: high, controls: SOC2-CC6.1, ISO-A.8.1, docs: https://wiki/labels]. " +
+		"team is missing, see control SOC2-CC1.3"
+	if *response.Message != expectedMessage {
+		t.Errorf("Got '%s' instead of '%s'", *response.Message, expectedMessage)
+	}
+}
+
+func TestRejectionMessageGroupsRulesSharingMetadata(t *testing.T) {
+	settings, err := NewSettingsFromValidateSettingsPayload([]byte(`
+	{
+		"version": 2,
+		"presets": [ "kubernetes-recommended" ],
+		"rules": [ { "type": "mandatory", "key": "team" } ]
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+		"test_data/ingress.json",
+		&settings)
+	if err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+
+	responsePayload, err := validate(payload)
+	if err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+
+	var response kubewarden_protocol.ValidationResponse
+	if err := json.Unmarshal(responsePayload, &response); err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+
+	if response.Accepted != false {
+		t.Error("Unexpected accept response")
+	}
+
+	expectedMessage := "The following mandatory labels are missing: team; " +
+		"app.kubernetes.io/instance,app.kubernetes.io/name [docs: " + kubernetesRecommendedLabelsDocs + "]"
+	if *response.Message != expectedMessage {
+		t.Errorf("Got '%s' instead of '%s'", *response.Message, expectedMessage)
+	}
+}
+
+func TestRejectionCodes(t *testing.T) {
+	cases := []struct {
+		name         string
+		settings     string
+		expectedCode *uint16
+	}{
+		{
+			name:         "no code configured",
+			settings:     `{"denied_labels": ["owner"]}`,
+			expectedCode: nil,
+		},
+		{
+			name:         "default code",
+			settings:     `{"denied_labels": ["owner"], "codes": {"default": 400}}`,
+			expectedCode: uint16Ptr(400),
+		},
+		{
+			name: "category code takes precedence over default one",
+			settings: `{
+				"denied_labels": ["owner"],
+				"codes": {"default": 400, "denied_labels": 403}
+			}`,
+			expectedCode: uint16Ptr(403),
+		},
+		{
+			name: "rule code takes precedence over category one",
+			settings: `{
+				"denied_labels": [{"key": "owner", "code": 451}],
+				"codes": {"denied_labels": 403}
+			}`,
+			expectedCode: uint16Ptr(451),
+		},
+		{
+			name: "denied labels take precedence over other categories",
+			settings: `{
+				"denied_labels": ["owner"],
+				"mandatory_labels": ["required"],
+				"constrained_labels": {"cc-center": "^cc-\\d+$"},
+				"codes": {"denied_labels": 403, "constrained_labels": 422, "mandatory_labels": 400}
+			}`,
+			expectedCode: uint16Ptr(403),
+		},
+		{
+			name: "constrained labels take precedence over mandatory ones",
+			settings: `{
+				"mandatory_labels": ["required"],
+				"constrained_labels": {"cc-center": "^cc-\\d+$"},
+				"codes": {"denied_labels": 403, "constrained_labels": 422, "mandatory_labels": 400}
+			}`,
+			expectedCode: uint16Ptr(422),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			settings, err := NewSettingsFromValidateSettingsPayload([]byte(tc.settings))
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+
+			payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+				"test_data/ingress.json",
+				&settings)
+			if err != nil {
+				t.Errorf("Unexpected error: %+v", err)
+			}
+
+			responsePayload, err := validate(payload)
+			if err != nil {
+				t.Errorf("Unexpected error: %+v", err)
+			}
+
+			var response kubewarden_protocol.ValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Errorf("Unexpected error: %+v", err)
+			}
+
+			if response.Accepted != false {
+				t.Error("Unexpected accept response")
+			}
+
+			switch {
+			case tc.expectedCode == nil && response.Code != nil:
+				t.Errorf("Didn't expect a code, got %d", *response.Code)
+			case tc.expectedCode != nil && response.Code == nil:
+				t.Errorf("Expected code %d, got none", *tc.expectedCode)
+			case tc.expectedCode != nil && *response.Code != *tc.expectedCode:
+				t.Errorf("Expected code %d, got %d", *tc.expectedCode, *response.Code)
+			}
+		})
+	}
+}
+
+func uint16Ptr(v uint16) *uint16 {
+	return &v
+}
+
+func TestRejectionIncludesSuggestions(t *testing.T) {
+	settings, err := NewSettingsFromValidateSettingsPayload([]byte(`
+	{
+		"mandatory_labels": [ "owner" ],
+		"constrained_labels": {
+			"environment": { "allowed_values": [ "prod", "staging" ] }
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+		"test_data/pod.json",
+		&settings)
+	if err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+
+	responsePayload, err := validate(payload)
+	if err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+
+	var response kubewarden_protocol.ValidationResponse
+	if err := json.Unmarshal(responsePayload, &response); err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+
+	if response.Accepted != false {
+		t.Error("Unexpected accept response")
+	}
+
+	expectedMessage := "The following labels are violating user constraints: environment. " +
+		"The following mandatory labels are missing: owner. " +
+		"Did you mean \"owner\" instead of \"ownr\"? " +
+		"Did you mean environment=prod instead of environment=prdo?"
+	if *response.Message != expectedMessage {
+		t.Errorf("Got '%s' instead of '%s'", *response.Message, expectedMessage)
+	}
+}
+
+func TestRejectionBecauseNearMissLabel(t *testing.T) {
+	settings, err := NewSettingsFromValidateSettingsPayload([]byte(`
+	{
+		"constrained_labels": { "owner": "^team-" },
+		"reject_near_miss_labels": true
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+		"test_data/pod.json",
+		&settings)
+	if err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+
+	responsePayload, err := validate(payload)
+	if err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+
+	var response kubewarden_protocol.ValidationResponse
+	if err := json.Unmarshal(responsePayload, &response); err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+
+	if response.Accepted != false {
+		t.Error("Unexpected accept response")
+	}
+
+	expectedMessage := "The following labels look like typos of other labels: ownr"
+	if *response.Message != expectedMessage {
+		t.Errorf("Got '%s' instead of '%s'", *response.Message, expectedMessage)
+	}
+}
+
+func TestRejectionBecauseLabelSyntaxNotValid(t *testing.T) {
+	settings := Settings{
+		DeniedLabels:     mapset.NewThreadUnsafeSet[string](),
+		MandatoryLabels:  mapset.NewThreadUnsafeSet[string](),
+		CheckLabelSyntax: true,
+	}
+
+	object := map[string]interface{}{
+		"apiVersion": "example.com/v1",
+		"kind":       "Widget",
+		"metadata": map[string]interface{}{
+			"name": "widget",
+			"labels": map[string]string{
+				"owner":        "team-infra",
+				"example.com/": "ok",
+				"tier":         "front end",
+			},
+		},
+	}
+
+	payload, err := kubewarden_testing.BuildValidationRequest(object, &settings)
+	if err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+
+	responsePayload, err := validate(payload)
+	if err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+
+	var response kubewarden_protocol.ValidationResponse
+	if err := json.Unmarshal(responsePayload, &response); err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+
+	if response.Accepted != false {
+		t.Error("Unexpected accept response")
+	}
+
+	expectedMessage := "The following labels are not valid: " +
+		"example.com/ (key name part must be non-empty); " +
+		"tier (value must be empty or consist of alphanumeric characters, '-', '_' or '.', " +
+		"and must start and end with an alphanumeric character)"
+	if *response.Message != expectedMessage {
+		t.Errorf("Got '%s' instead of '%s'", *response.Message, expectedMessage)
+	}
+}